@@ -5,7 +5,11 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
+	"os"
 	"regexp"
+	"strconv"
+
+	"github.com/packrat386/atalanta/markdown"
 )
 
 func newArticleHandler(s storage, tmpl *template.Template) http.Handler {
@@ -37,15 +41,58 @@ func postArticle(w http.ResponseWriter, r *http.Request, s storage, tmpl *templa
 		return
 	}
 
+	switch r.Form.Get("action") {
+	case "move":
+		moveArticle(w, r, s, tmpl, title)
+		return
+	case "delete":
+		deleteArticle(w, r, s, tmpl, title)
+		return
+	case "toggle_task":
+		toggleTask(w, r, s, tmpl, title)
+		return
+	}
+
 	content := r.Form.Get("content")
+	meta := versionMeta{
+		Author:  requestAuthor(r),
+		Summary: r.Form.Get("summary"),
+	}
 
 	err = checkmd([]byte(content))
-	if err != nil {
+
+	var parseErr *markdown.ParseError
+	if errors.As(err, &parseErr) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		render(
+			w,
+			tmpl,
+			"edit_article.tmpl",
+			editArticleView{
+				Title:         title,
+				BaseVersionID: r.Form.Get("base_version_id"),
+				Content:       content,
+				Summary:       meta.Summary,
+				ParseError:    parseErr,
+			},
+		)
+
+		return
+	} else if err != nil {
 		renderError(w, tmpl, fmt.Errorf("article not saved: %w", err))
+		return
 	}
 
-	err = s.WriteArticle(title, []byte(content))
-	if err != nil {
+	if _, ok := r.Form["base_version_id"]; ok {
+		err = s.WriteArticleIfCurrent(title, r.Form.Get("base_version_id"), []byte(content), meta)
+	} else {
+		err = s.WriteArticle(title, []byte(content), meta)
+	}
+
+	if errors.Is(err, errVersionConflict) {
+		mergeArticle(w, r, s, tmpl, title, r.Form.Get("base_version_id"), content, meta)
+		return
+	} else if err != nil {
 		renderError(w, tmpl, fmt.Errorf("could not write article content:  %w", err))
 		return
 	}
@@ -53,14 +100,155 @@ func postArticle(w http.ResponseWriter, r *http.Request, s storage, tmpl *templa
 	http.Redirect(w, r, r.URL.Path, http.StatusFound)
 }
 
+// toggleTask checks or unchecks a task on the article page, saving the
+// version that was shown with only the task's line changed. If that version
+// has been pruned the task can't be found in it, so the user is asked to
+// reload the page rather than having a line of another version toggled.
+func toggleTask(w http.ResponseWriter, r *http.Request, s storage, tmpl *template.Template, title string) {
+	line, err := strconv.Atoi(r.Form.Get("task"))
+	if err != nil {
+		renderError(w, tmpl, fmt.Errorf("invalid task: %w", err))
+		return
+	}
+
+	base := r.Form.Get("base_version_id")
+
+	content, err := s.ReadArticleVersion(title, base)
+	if errors.Is(err, os.ErrNotExist) {
+		// the version that was shown has been pruned, so there's no telling
+		// which line of the current version the task is on
+		w.WriteHeader(http.StatusConflict)
+		render(w, tmpl, "error.tmpl", errorView{ErrorMessage: "The page is out of date, reload it and try again."})
+		return
+	} else if err != nil {
+		renderError(w, tmpl, fmt.Errorf("could not read article: %w", err))
+		return
+	}
+
+	toggled, err := markdown.ToggleTask(content, line)
+	if err != nil {
+		renderError(w, tmpl, fmt.Errorf("could not toggle task: %w", err))
+		return
+	}
+
+	meta := versionMeta{
+		Author:  requestAuthor(r),
+		Summary: fmt.Sprintf("Toggled the task on line %d", line),
+	}
+
+	err = s.WriteArticleIfCurrent(title, base, toggled, meta)
+	if errors.Is(err, errVersionConflict) {
+		mergeArticle(w, r, s, tmpl, title, base, string(toggled), meta)
+		return
+	} else if err != nil {
+		renderError(w, tmpl, fmt.Errorf("could not write article content:  %w", err))
+		return
+	}
+
+	http.Redirect(w, r, r.URL.Path+"?tasks=true", http.StatusFound)
+}
+
+type editConflictView struct {
+	Title            string
+	CurrentVersionID string
+	CurrentContent   string
+	Content          string
+	Summary          string
+
+	// TooDifferent is set when the texts were not merged at all because too
+	// much changed to compare them
+	TooDifferent bool
+}
+
+// mergeArticle handles a save whose base version is no longer current by
+// merging the submitted content with the changes made since base. A clean
+// merge is saved, otherwise the user is asked to resolve the conflicts.
+//
+// The edit form may have been open long enough for base to be pruned. The
+// merge then treats it as empty, so every line counts as changed on both
+// sides and the user resolves the whole article.
+func mergeArticle(w http.ResponseWriter, r *http.Request, s storage, tmpl *template.Template, title, base, content string, meta versionMeta) {
+	baseContent := []byte{}
+	if base != "" {
+		var err error
+		baseContent, err = s.ReadArticleVersion(title, base)
+		if err != nil {
+			// the base may have been pruned, merge as if everything changed
+			baseContent = []byte{}
+		}
+	}
+
+	currentVersionID, err := s.CurrentArticleVersion(title)
+	if err != nil {
+		renderError(w, tmpl, fmt.Errorf("could not get current article version: %w", err))
+		return
+	}
+
+	currentContent, err := s.ReadArticleVersion(title, currentVersionID)
+	if err != nil {
+		renderError(w, tmpl, fmt.Errorf("could not read article: %w", err))
+		return
+	}
+
+	merged, clean, err := merge3(baseContent, currentContent, []byte(content))
+	tooDifferent := errors.Is(err, errTooDifferent)
+	if tooDifferent {
+		// too much changed to merge, have the user combine the texts
+		merged, clean = []byte(content), false
+	} else if err != nil {
+		renderError(w, tmpl, fmt.Errorf("could not merge article content: %w", err))
+		return
+	}
+
+	if clean {
+		err = s.WriteArticleIfCurrent(title, currentVersionID, merged, meta)
+		if err == nil {
+			http.Redirect(w, r, r.URL.Path, http.StatusFound)
+			return
+		} else if !errors.Is(err, errVersionConflict) {
+			renderError(w, tmpl, fmt.Errorf("could not write article content:  %w", err))
+			return
+		}
+
+		// somebody beat us again, have the user look it over
+	}
+
+	w.WriteHeader(http.StatusConflict)
+	render(
+		w,
+		tmpl,
+		"edit_conflict.tmpl",
+		editConflictView{
+			Title:            title,
+			CurrentVersionID: currentVersionID,
+			CurrentContent:   string(currentContent),
+			Content:          string(merged),
+			Summary:          meta.Summary,
+			TooDifferent:     tooDifferent,
+		},
+	)
+}
+
 type articleView struct {
-	Title   string
-	Content template.HTML
+	Title          string
+	VersionID      string
+	Content        template.HTML
+	RedirectedFrom string
+	RedirectTarget string
+	RedirectError  string
+	HasTasks       bool
+	// ToggleTasks shows the article in a form whose task checkboxes can be
+	// clicked, which is only done when asked for with ?tasks=true.
+	ToggleTasks bool
 }
 
 type editArticleView struct {
-	Title   string
-	Content string
+	Title         string
+	BaseVersionID string
+	Content       string
+	Summary       string
+	// ParseError is why the content was not saved, if it couldn't be parsed.
+	ParseError *markdown.ParseError
 }
 
 func getArticle(w http.ResponseWriter, r *http.Request, s storage, tmpl *template.Template) {
@@ -70,9 +258,14 @@ func getArticle(w http.ResponseWriter, r *http.Request, s storage, tmpl *templat
 		return
 	}
 
+	redirectedFrom := r.URL.Query().Get("redirected_from")
+	if !titleMatcher.MatchString(redirectedFrom) {
+		redirectedFrom = ""
+	}
+
 	content, err := s.ReadArticle(title)
 	if errors.Is(err, errArticleDNE) {
-		render(w, tmpl, "article_dne.tmpl", articleView{Title: title})
+		render(w, tmpl, "article_dne.tmpl", articleView{Title: title, RedirectedFrom: redirectedFrom})
 		return
 	} else if err != nil {
 		renderError(w, tmpl, fmt.Errorf("could not read article: %w", err))
@@ -84,23 +277,86 @@ func getArticle(w http.ResponseWriter, r *http.Request, s storage, tmpl *templat
 		return
 	}
 
+	if r.URL.Query().Get("move") == "true" {
+		render(w, tmpl, "move_article.tmpl", moveArticleView{Title: title})
+		return
+	}
+
+	if r.URL.Query().Get("delete") == "true" {
+		render(w, tmpl, "delete_article.tmpl", articleView{Title: title})
+		return
+	}
+
 	if r.URL.Query().Get("edit") == "true" {
+		// read the version before its content so a concurrent write can
+		// only make our base look older, never newer
+		baseVersionID, err := s.CurrentArticleVersion(title)
+		if err != nil {
+			renderError(w, tmpl, fmt.Errorf("could not get current article version: %w", err))
+			return
+		}
+
+		content, err = s.ReadArticleVersion(title, baseVersionID)
+		if err != nil {
+			renderError(w, tmpl, fmt.Errorf("could not read article: %w", err))
+			return
+		}
+
 		render(
 			w,
 			tmpl,
 			"edit_article.tmpl",
 			editArticleView{
-				Title:   title,
-				Content: string(content),
+				Title:         title,
+				BaseVersionID: baseVersionID,
+				Content:       string(content),
 			},
 		)
 
 		return
 	}
 
-	contentHTML, err := md2html(content)
+	if target, ok := redirectTarget(content); ok {
+		view := articleView{
+			Title:          title,
+			RedirectedFrom: redirectedFrom,
+			RedirectTarget: target,
+		}
+
+		if r.URL.Query().Get("redirect") != "no" {
+			final, err := resolveRedirects(s, title, target)
+			if err == nil {
+				http.Redirect(w, r, fmt.Sprintf("/articles/%s?redirected_from=%s", final, title), http.StatusFound)
+				return
+			}
+
+			view.RedirectError = err.Error()
+		}
+
+		render(w, tmpl, "show_article.tmpl", view)
+		return
+	}
+
+	toggleTasks := r.URL.Query().Get("tasks") == "true"
+
+	// tasks are toggled in the version shown, so like editing read the
+	// version before its content
+	versionID, err := s.CurrentArticleVersion(title)
+	if err != nil {
+		renderError(w, tmpl, fmt.Errorf("could not get current article version: %w", err))
+		return
+	}
+
+	content, err = s.ReadArticleVersion(title, versionID)
+	if err != nil {
+		renderError(w, tmpl, fmt.Errorf("could not read article: %w", err))
+		return
+	}
+
+	contentHTML, err := md2html(content, s, toggleTasks)
 	if err != nil {
 		renderError(w, tmpl, err)
+		return
 	}
 
 	render(
@@ -108,13 +364,20 @@ func getArticle(w http.ResponseWriter, r *http.Request, s storage, tmpl *templat
 		tmpl,
 		"show_article.tmpl",
 		articleView{
-			Title:   title,
-			Content: contentHTML,
+			Title:          title,
+			VersionID:      versionID,
+			Content:        contentHTML,
+			ToggleTasks:    toggleTasks,
+			HasTasks:       hasTasks(content),
+			RedirectedFrom: redirectedFrom,
 		},
 	)
 }
 
-var articlePathMatcher = regexp.MustCompile(`^/articles/([0-9a-zA-Z_]+)$`)
+var (
+	articlePathMatcher = regexp.MustCompile(`^/articles/([0-9a-zA-Z_]+)$`)
+	titleMatcher       = regexp.MustCompile(`^[0-9a-zA-Z_]+$`)
+)
 
 func articleTitle(r *http.Request) (string, error) {
 	matches := articlePathMatcher.FindStringSubmatch(r.URL.Path)
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func newTestArticleHandler(t *testing.T) (http.Handler, storage) {
+	tmpl, err := template.ParseFS(templatesFS, "templates/*.tmpl")
+	if err != nil {
+		t.Fatalf("could not parse templates: %s", err.Error())
+	}
+
+	s, err := NewLocalStorage(t.TempDir(), retentionPolicy{})
+	if err != nil {
+		t.Fatalf("could not make storage: %s", err.Error())
+	}
+
+	return newArticleHandler(s, tmpl), s
+}
+
+func postForm(h http.Handler, path string, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestPostArticleParseError(t *testing.T) {
+	h, s := newTestArticleHandler(t)
+
+	w := postForm(h, "/articles/New", url.Values{"content": {"fine\nnot \xff fine\n"}})
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status %d, got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+
+	if !strings.Contains(w.Body.String(), "could not be parsed at line 2, column 5") {
+		t.Fatalf("expected the edit form to show where the error is, got:\n%s", w.Body.String())
+	}
+
+	_, err := s.ReadArticle("New")
+	if !errors.Is(err, errArticleDNE) {
+		t.Fatalf("expected the article not to be written, got %v", err)
+	}
+}
+
+func TestPostArticleParseErrorKeepsCurrentVersion(t *testing.T) {
+	h, s := newTestArticleHandler(t)
+
+	w := postForm(h, "/articles/Existing", url.Values{"content": {"first version\n"}})
+	if w.Code != http.StatusFound {
+		t.Fatalf("expected status %d, got %d", http.StatusFound, w.Code)
+	}
+
+	base, err := s.CurrentArticleVersion("Existing")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	w = postForm(h, "/articles/Existing", url.Values{
+		"content":         {strings.Repeat("> ", 40) + "too deep\n"},
+		"base_version_id": {base},
+	})
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status %d, got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+
+	if !strings.Contains(w.Body.String(), `name="base_version_id" value="`+base+`"`) {
+		t.Fatalf("expected the edit form to keep its base version, got:\n%s", w.Body.String())
+	}
+
+	current, err := s.CurrentArticleVersion("Existing")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	if current != base {
+		t.Fatalf("expected no new version to be written")
+	}
+}
+
+func getPage(h http.Handler, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+
+	return w
+}
+
+func TestArticleTasks(t *testing.T) {
+	h, s := newTestArticleHandler(t)
+
+	err := s.WriteArticle("Todo", []byte("- [ ] one\n- [x] two\n"), versionMeta{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	body := getPage(h, "/articles/Todo").Body.String()
+
+	if strings.Contains(body, "<form") || strings.Contains(body, "task-toggle") {
+		t.Fatalf("expected tasks not to be toggleable by default, got:\n%s", body)
+	}
+
+	if !strings.Contains(body, `<input type="checkbox" disabled>`) || !strings.Contains(body, "?tasks=true") {
+		t.Fatalf("expected disabled checkboxes and a link to check off tasks, got:\n%s", body)
+	}
+
+	body = getPage(h, "/articles/Todo?tasks=true").Body.String()
+
+	if !strings.Contains(body, `name="action" value="toggle_task"`) || !strings.Contains(body, `class="task-toggle" name="task" value="1"`) {
+		t.Fatalf("expected tasks to be toggleable, got:\n%s", body)
+	}
+}
+
+func TestToggleTaskPrunedBase(t *testing.T) {
+	h, s := newTestArticleHandler(t)
+
+	err := s.WriteArticle("Todo", []byte("- [ ] one\n- [x] two\n"), versionMeta{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	base, err := s.CurrentArticleVersion("Todo")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	// a task added above the others moves them down a line
+	err = s.WriteArticle("Todo", []byte("- [x] zero\n- [ ] one\n- [x] two\n"), versionMeta{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	err = os.Remove(s.(*localStorage).relpath("Todo", base))
+	if err != nil {
+		t.Fatalf("could not prune version: %s", err.Error())
+	}
+
+	// "two" is on line 2 of the pruned version, but line 2 of the current
+	// version is "one"
+	w := postForm(h, "/articles/Todo", url.Values{
+		"action":          {"toggle_task"},
+		"base_version_id": {base},
+		"task":            {"2"},
+	})
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected status %d, got %d:\n%s", http.StatusConflict, w.Code, w.Body.String())
+	}
+
+	if !strings.Contains(w.Body.String(), "out of date") {
+		t.Fatalf("expected to be asked to reload, got:\n%s", w.Body.String())
+	}
+
+	content, err := s.ReadArticle("Todo")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	if string(content) != "- [x] zero\n- [ ] one\n- [x] two\n" {
+		t.Fatalf("expected no task to be toggled, got %q", content)
+	}
+}
+
+func TestToggleTaskInvalidBase(t *testing.T) {
+	h, s := newTestArticleHandler(t)
+
+	err := s.WriteArticle("Todo", []byte("- [ ] one\n"), versionMeta{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	for _, base := range []string{"", "not a version"} {
+		w := postForm(h, "/articles/Todo", url.Values{
+			"action":          {"toggle_task"},
+			"base_version_id": {base},
+			"task":            {"1"},
+		})
+
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("base %q: expected status %d, got %d", base, http.StatusInternalServerError, w.Code)
+		}
+	}
+
+	content, err := s.ReadArticle("Todo")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	if string(content) != "- [ ] one\n" {
+		t.Fatalf("expected no task to be toggled, got %q", content)
+	}
+}
+
+func TestGetArticleBreakingParseLimits(t *testing.T) {
+	h, s := newTestArticleHandler(t)
+
+	// saved before the limits were checked, so they have to render anyway
+	tt := map[string]string{
+		"Invalid": "a\xffb\n",
+		"Deep":    strings.Repeat("> ", 40) + "deep\n",
+	}
+
+	for title, content := range tt {
+		err := s.WriteArticle(title, []byte(content), versionMeta{})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+
+		w := getPage(h, "/articles/"+title)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d:\n%s", title, http.StatusOK, w.Code, w.Body.String())
+		}
+	}
+
+	w := getPage(h, "/articles/Invalid")
+	if !strings.Contains(w.Body.String(), "a\uFFFDb") {
+		t.Fatalf("expected the invalid byte to be replaced, got:\n%s", w.Body.String())
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+)
+
+// maxDiffEdits is the most edits a diff will look for. Finding a diff takes
+// time that grows with the number of edits times the length of the texts,
+// and memory that grows with the square of the number of edits, so texts
+// that differ by more than this aren't diffed.
+const maxDiffEdits = 1000
+
+var errTooDifferent = errors.New("error: texts are too different to compare")
+
+type diffOp int64
+
+const (
+	diffEqual diffOp = iota
+	diffDelete
+	diffInsert
+)
+
+type diffEdit struct {
+	op   diffOp
+	text string
+}
+
+func splitLines(content []byte) []string {
+	if len(content) == 0 {
+		return []string{}
+	}
+
+	lines := []string{}
+	for _, l := range bytes.SplitAfter(content, []byte("\n")) {
+		if len(l) != 0 {
+			lines = append(lines, string(l))
+		}
+	}
+
+	return lines
+}
+
+// diffStrings returns a shortest edit script that turns a into b, or
+// errTooDifferent if it would take more than maxDiffEdits edits.
+func diffStrings(a, b []string) ([]diffEdit, error) {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	edits := []diffEdit{}
+
+	for _, s := range a[:prefix] {
+		edits = append(edits, diffEdit{op: diffEqual, text: s})
+	}
+
+	middle, err := myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])
+	if err != nil {
+		return nil, err
+	}
+
+	edits = append(edits, middle...)
+
+	for _, s := range a[len(a)-suffix:] {
+		edits = append(edits, diffEdit{op: diffEqual, text: s})
+	}
+
+	return edits, nil
+}
+
+// myers is the greedy algorithm from "An O(ND) Difference Algorithm and Its
+// Variations". Only the diagonals reachable in each round are kept in the
+// trace, so memory is O(D^2) rather than O(D(N+M)), and D is at most
+// maxDiffEdits.
+func myers(a, b []string) ([]diffEdit, error) {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+
+	v := make([]int, 2*max+3)
+	trace := [][]int{}
+
+	for d := 0; d <= max; d++ {
+		if d > maxDiffEdits {
+			return nil, errTooDifferent
+		}
+
+		trace = append(trace, append([]int{}, v[offset-d-1:offset+d+2]...))
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				return backtrack(trace, a, b), nil
+			}
+		}
+	}
+
+	panic("unreachable: no edit script found")
+}
+
+func backtrack(trace [][]int, a, b []string) []diffEdit {
+	reversed := []diffEdit{}
+	x, y := len(a), len(b)
+
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := func(k int) int { return trace[d][k+d+1] }
+		k := x - y
+
+		var prevK int
+		if k == -d || (k != d && v(k-1) < v(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+
+		prevX := v(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			reversed = append(reversed, diffEdit{op: diffEqual, text: a[x-1]})
+			x--
+			y--
+		}
+
+		if d > 0 {
+			if x == prevX {
+				reversed = append(reversed, diffEdit{op: diffInsert, text: b[y-1]})
+			} else {
+				reversed = append(reversed, diffEdit{op: diffDelete, text: a[x-1]})
+			}
+
+			x, y = prevX, prevY
+		}
+	}
+
+	edits := make([]diffEdit, len(reversed))
+	for i, e := range reversed {
+		edits[len(reversed)-1-i] = e
+	}
+
+	return edits
+}
+
+var wordMatcher = regexp.MustCompile(`\s+|\w+|[^\s\w]`)
+
+// splitWords splits a line into words, runs of whitespace and punctuation
+// such that joining the pieces gives back the line.
+func splitWords(line string) []string {
+	return wordMatcher.FindAllString(line, -1)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffStrings(t *testing.T) {
+	tt := []struct {
+		name  string
+		a     string
+		b     string
+		edits int
+	}{
+		{"both empty", "", "", 0},
+		{"equal", "a\nb\nc\n", "a\nb\nc\n", 0},
+		{"a empty", "", "a\nb\n", 2},
+		{"b empty", "a\nb\n", "", 2},
+		{"insert", "a\nc\n", "a\nb\nc\n", 1},
+		{"delete", "a\nb\nc\n", "a\nc\n", 1},
+		{"replace", "a\nb\nc\n", "a\nB\nc\n", 2},
+		{"paper example", "a\nb\nc\na\nb\nb\na\n", "c\nb\na\nb\na\nc\n", 5},
+		{"no final newline", "a\nb", "a\nb\n", 2},
+		{"repeated lines", "x\nx\nx\n", "x\ny\nx\n", 2},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			a := splitLines([]byte(tc.a))
+			b := splitLines([]byte(tc.b))
+
+			edits, err := diffStrings(a, b)
+			if err != nil {
+				t.Fatalf("could not diff: %s", err)
+			}
+
+			// the equal and deleted lines make up a, the equal and inserted
+			// lines make up b
+			fromA, fromB := []string{}, []string{}
+			changes := 0
+
+			for _, e := range edits {
+				switch e.op {
+				case diffEqual:
+					fromA = append(fromA, e.text)
+					fromB = append(fromB, e.text)
+				case diffDelete:
+					fromA = append(fromA, e.text)
+					changes++
+				case diffInsert:
+					fromB = append(fromB, e.text)
+					changes++
+				}
+			}
+
+			if !reflect.DeepEqual(fromA, a) {
+				t.Fatalf("edits do not give back a: %q", strings.Join(fromA, ""))
+			}
+
+			if !reflect.DeepEqual(fromB, b) {
+				t.Fatalf("edits do not give back b: %q", strings.Join(fromB, ""))
+			}
+
+			if changes != tc.edits {
+				t.Fatalf("expected %d edits, got %d", tc.edits, changes)
+			}
+		})
+	}
+}
+
+func TestDiffStringsTooDifferent(t *testing.T) {
+	a := make([]string, maxDiffEdits)
+	b := make([]string, maxDiffEdits)
+	for i := range a {
+		a[i] = fmt.Sprintf("a%d\n", i)
+		b[i] = fmt.Sprintf("b%d\n", i)
+	}
+
+	_, err := diffStrings(a, b)
+	if !errors.Is(err, errTooDifferent) {
+		t.Fatalf("expected errTooDifferent, got %v", err)
+	}
+
+	// the same number of lines is fine when few of them changed
+	b = append([]string{}, a...)
+	b[0] = "changed\n"
+
+	if _, err := diffStrings(a, b); err != nil {
+		t.Fatalf("could not diff: %s", err)
+	}
+}
+
+func TestSplitLines(t *testing.T) {
+	tt := []struct {
+		input    string
+		expected []string
+	}{
+		{"", []string{}},
+		{"a", []string{"a"}},
+		{"a\n", []string{"a\n"}},
+		{"a\nb", []string{"a\n", "b"}},
+		{"\n\n", []string{"\n", "\n"}},
+	}
+
+	for _, tc := range tt {
+		actual := splitLines([]byte(tc.input))
+
+		if !reflect.DeepEqual(actual, tc.expected) {
+			t.Fatalf("input %q: expected %q, got %q", tc.input, tc.expected, actual)
+		}
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
 //go:embed templates/*
@@ -35,11 +36,27 @@ func main() {
 		storageBaseDirectory = "."
 	}
 
-	storage, err := NewLocalStorage(storageBaseDirectory)
+	retention, err := newRetentionPolicy(
+		os.Getenv("ATALANTA_RETAIN_LAST"),
+		os.Getenv("ATALANTA_RETAIN_WITHIN"),
+		os.Getenv("ATALANTA_RETAIN_THIN"),
+	)
+	if err != nil {
+		panic(fmt.Errorf("could not configure retention policy: %w", err))
+	}
+
+	storage, err := NewLocalStorage(storageBaseDirectory, retention)
 	if err != nil {
 		panic(err)
 	}
 
+	index := newSearchIndex()
+
+	storage, err = newIndexedStorage(storage, index)
+	if err != nil {
+		panic(fmt.Errorf("could not build search index: %w", err))
+	}
+
 	title := os.Getenv("ATALANTA_WIKI_TITLE")
 	if title == "" {
 		title = "Atalanta"
@@ -50,11 +67,18 @@ func main() {
 		blurb = "Run free"
 	}
 
+	loc, err := time.LoadLocation(os.Getenv("ATALANTA_TIME_ZONE"))
+	if err != nil {
+		panic(fmt.Errorf("could not load time zone: %w", err))
+	}
+
 	mux := http.NewServeMux()
 	mux.Handle("/goto", newGotoHandler())
+	mux.Handle("/search", newSearchHandler(index, tmpl))
 	mux.Handle("/articles/", newArticleHandler(storage, tmpl))
 	mux.Handle("/articles", newArticlesHandler(storage, tmpl))
-	mux.Handle("/versions/", newVersionHandler(storage, tmpl))
+	mux.Handle("/versions/", newVersionHandler(storage, loc, tmpl))
+	mux.Handle("/trash", newTrashHandler(storage, os.Getenv("ATALANTA_ADMIN_USER"), os.Getenv("ATALANTA_ADMIN_PASSWORD"), loc, tmpl))
 	mux.Handle("/", newPublicHandler(title, blurb, public, tmpl))
 
 	srv := http.Server{
@@ -0,0 +1,505 @@
+package markdown
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// NodeKind identifies what a Node is.
+type NodeKind int64
+
+const (
+	NodeDocument NodeKind = iota
+	NodeHeading
+	NodeThematicBreak
+	NodeBlockQuote
+	NodeCodeBlock
+	NodeUnorderedList
+	NodeOrderedList
+	NodeListItem
+	NodeTableOfContents
+	NodeParagraph
+	NodeTable
+	NodeTableHead
+	NodeTableBody
+	NodeTableRow
+	NodeTableCell
+	NodeText
+	NodeCode
+	NodeEmph
+	NodeStrong
+	NodeStrikethrough
+	NodeLink
+	NodeImage
+	NodeWikiLink
+	NodeLineBreak
+)
+
+func (k NodeKind) String() string {
+	switch k {
+	case NodeDocument:
+		return "DOCUMENT"
+	case NodeHeading:
+		return "HEADING"
+	case NodeThematicBreak:
+		return "THEMATIC_BREAK"
+	case NodeBlockQuote:
+		return "BLOCK_QUOTE"
+	case NodeCodeBlock:
+		return "CODE_BLOCK"
+	case NodeUnorderedList:
+		return "UNORDERED_LIST"
+	case NodeOrderedList:
+		return "ORDERED_LIST"
+	case NodeListItem:
+		return "LIST_ITEM"
+	case NodeTableOfContents:
+		return "TABLE_OF_CONTENTS"
+	case NodeParagraph:
+		return "PARAGRAPH"
+	case NodeTable:
+		return "TABLE"
+	case NodeTableHead:
+		return "TABLE_HEAD"
+	case NodeTableBody:
+		return "TABLE_BODY"
+	case NodeTableRow:
+		return "TABLE_ROW"
+	case NodeTableCell:
+		return "TABLE_CELL"
+	case NodeText:
+		return "TEXT"
+	case NodeCode:
+		return "CODE"
+	case NodeEmph:
+		return "EMPH"
+	case NodeStrong:
+		return "STRONG"
+	case NodeStrikethrough:
+		return "STRIKETHROUGH"
+	case NodeLink:
+		return "LINK"
+	case NodeImage:
+		return "IMAGE"
+	case NodeWikiLink:
+		return "WIKI_LINK"
+	case NodeLineBreak:
+		return "LINE_BREAK"
+	default:
+		panic(fmt.Sprint("unrecognized node kind: ", int64(k)))
+	}
+}
+
+// Position is a place in the markdown source. Lines and columns count from 1
+// and columns count bytes.
+type Position struct {
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// ParseError is returned for markdown the parser won't accept. Pos is where
+// the problem starts.
+type ParseError struct {
+	Pos Position
+	Msg string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Pos.Line, e.Pos.Column, e.Msg)
+}
+
+// maxNesting is how many quotes and lists deep blocks are parsed. Lazy lines
+// are checked against every level a quote's content ends in, so without a
+// limit deep nesting would make parsing slow.
+const maxNesting = 32
+
+// checkUTF8 returns a *ParseError at the first byte of input that isn't
+// valid UTF-8.
+func checkUTF8(input []byte) error {
+	if utf8.Valid(input) {
+		return nil
+	}
+
+	pos := Position{Line: 1, Column: 1}
+
+	for i := 0; i < len(input); {
+		r, size := utf8.DecodeRune(input[i:])
+		if r == utf8.RuneError && size == 1 {
+			return &ParseError{Pos: pos, Msg: "the text is not valid UTF-8"}
+		}
+
+		if r == '\n' {
+			pos = Position{Line: pos.Line + 1, Column: 1}
+		} else {
+			pos.Column += size
+		}
+
+		i += size
+	}
+
+	return nil
+}
+
+// Node is an element of a parsed document. Blocks contain other blocks or
+// inlines, and some inlines contain other inlines. Beyond Kind, Pos and
+// Children, a field is only set for the kinds of node noted next to it.
+type Node struct {
+	Kind     NodeKind
+	Pos      Position
+	Children []*Node
+
+	// Text, Code and CodeBlock
+	Literal []byte
+
+	// Heading, ID is only set for the wiki's dialect
+	Level int
+	ID    string
+
+	// CodeBlock
+	Info string
+
+	// OrderedList
+	Start int
+
+	// UnorderedList, OrderedList and ListItem, whether the paragraphs of the
+	// list's items are rendered without <p> tags
+	Tight bool
+
+	// ListItem, whether the item starts with a checkbox, [ ] or [x], and
+	// whether it is checked. The checkbox is where the item's first child
+	// starts.
+	Task    bool
+	Checked bool
+
+	// TableCell, one of "left", "center" or "right", or empty for the default
+	Align string
+
+	// Link and Image
+	Destination string
+	Title       string
+
+	// WikiLink, the title of the linked article
+	Target string
+}
+
+// Walk visits a node and then its children, depth first. The children of a
+// node are skipped if visit returns false.
+func Walk(n *Node, visit func(n *Node) bool) {
+	if !visit(n) {
+		return
+	}
+
+	for _, c := range n.Children {
+		Walk(c, visit)
+	}
+}
+
+// Text returns the text of a node and its descendants with any formatting
+// removed.
+func (n *Node) Text() string {
+	var buf bytes.Buffer
+
+	Walk(n, func(c *Node) bool {
+		buf.Write(c.Literal)
+		return true
+	})
+
+	return buf.String()
+}
+
+// Language is the first word of a code block's info string.
+func (n *Node) Language() string {
+	fields := strings.Fields(n.Info)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	return fields[0]
+}
+
+// ParseOptions changes how markdown is parsed.
+type ParseOptions struct {
+	// CommonMark parses markdown as specified by CommonMark
+	// (https://spec.commonmark.org) instead of the wiki's own dialect. The
+	// wiki's extensions, like [[Title]] links, tables, tables of contents and
+	// heading ids, aren't recognized. Raw HTML isn't supported, it is escaped
+	// like any other text.
+	CommonMark bool
+
+	// URLSchemes are the schemes links and images may use. Relative URLs are
+	// always allowed. Links to any other scheme are left as plain text. If
+	// it is nil DefaultURLSchemes are allowed.
+	URLSchemes []string
+
+	// Strict rejects input that isn't valid UTF-8 or nests quotes and lists
+	// more than 32 deep. Otherwise invalid bytes are replaced with U+FFFD and
+	// quotes and lists past that depth are left as plain text.
+	Strict bool
+
+	// how many quotes and lists the blocks being parsed are inside
+	depth int
+}
+
+// inside returns the options for parsing the contents of a quote or list.
+func (o ParseOptions) inside() ParseOptions {
+	o.depth++
+	return o
+}
+
+// DefaultURLSchemes are the schemes links and images may use unless
+// ParseOptions says otherwise.
+var DefaultURLSchemes = []string{"http", "https", "mailto"}
+
+// Parse parses markdown in the wiki's dialect into a document. If the input
+// isn't accepted the error is a *ParseError.
+func Parse(input []byte) (*Node, error) {
+	return ParseWithOptions(input, ParseOptions{})
+}
+
+// ParseWithOptions parses markdown into a document. If the input isn't
+// accepted the error is a *ParseError.
+func ParseWithOptions(input []byte, opts ParseOptions) (*Node, error) {
+	opts.depth = 0
+	input = sanitizeNewlines(input)
+
+	if opts.Strict {
+		err := checkUTF8(input)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		input = bytes.ToValidUTF8(input, []byte("\uFFFD"))
+	}
+
+	lines := []Position{}
+	for i := range bytes.SplitAfter(input, []byte("\n")) {
+		lines = append(lines, Position{Line: i + 1, Column: 1})
+	}
+
+	parsed, err := parseBlocks(input, lines, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := parsed.blocks
+
+	doc := &Node{
+		Kind:     NodeDocument,
+		Pos:      Position{Line: 1, Column: 1},
+		Children: blocksToNodes(blocks, opts, collectLinkDefinitions(blocks, linkDefinitions{})),
+	}
+
+	if !opts.CommonMark {
+		assignHeadingIDs(doc)
+	}
+
+	return doc, nil
+}
+
+// collectLinkDefinitions adds the link definitions in blocks, and any blocks
+// they contain, to defs. If more than one definition has the same label the
+// first is used.
+func collectLinkDefinitions(blocks []*block, defs linkDefinitions) linkDefinitions {
+	for _, b := range blocks {
+		if b.kind == blockLinkDefinition {
+			if _, ok := defs[normalizeLabel(b.label)]; !ok {
+				defs[normalizeLabel(b.label)] = b
+			}
+		}
+
+		collectLinkDefinitions(b.children, defs)
+	}
+
+	return defs
+}
+
+func blocksToNodes(blocks []*block, opts ParseOptions, defs linkDefinitions) []*Node {
+	nodes := []*Node{}
+
+	for _, b := range blocks {
+		if b.kind == blockBlank || b.kind == blockLinkDefinition {
+			continue
+		}
+
+		nodes = append(nodes, blockToNode(b, opts, defs))
+	}
+
+	return nodes
+}
+
+func blockToNode(b *block, opts ParseOptions, defs linkDefinitions) *Node {
+	n := &Node{Pos: b.pos, Children: []*Node{}}
+
+	switch b.kind {
+	case blockH1, blockH2, blockH3, blockH4, blockH5, blockH6:
+		// headings aren't formatted
+		n.Kind = NodeHeading
+		n.Level = int(b.kind-blockH1) + 1
+
+		if opts.CommonMark {
+			// CommonMark headings are formatted like paragraphs
+			text := bytes.TrimRight(b.text, " \t\n")
+			if !b.setext {
+				text = trimATXHeading(text)
+			}
+
+			n.Children = parseSpans(text, b.lines, opts, defs)
+			break
+		}
+
+		if text := bytes.TrimSuffix(b.text, []byte("\n")); len(text) > 0 {
+			n.Children = append(n.Children, &Node{
+				Kind:    NodeText,
+				Pos:     b.lines[0],
+				Literal: text,
+			})
+		}
+	case blockQuote:
+		n.Kind = NodeBlockQuote
+		n.Children = blocksToNodes(b.children, opts, defs)
+	case blockThematicBreak:
+		n.Kind = NodeThematicBreak
+	case blockCode, blockIndentedCode:
+		n.Kind = NodeCodeBlock
+		n.Info = string(b.info)
+
+		// CommonMark code ends with a newline like every line of it
+		n.Literal = b.text
+		if !opts.CommonMark {
+			n.Literal = bytes.TrimSuffix(b.text, []byte("\n"))
+		}
+	case blockUnorderedList, blockOrderedList:
+		n.Kind = NodeUnorderedList
+		if b.kind == blockOrderedList {
+			n.Kind = NodeOrderedList
+			n.Start = b.start
+		}
+
+		n.Tight = b.tight
+
+		for _, item := range b.children {
+			n.Children = append(n.Children, &Node{
+				Kind:     NodeListItem,
+				Pos:      item.pos,
+				Tight:    item.tight,
+				Task:     item.task,
+				Checked:  item.checked,
+				Children: blocksToNodes(item.children, opts, defs),
+			})
+		}
+	case blockTOC:
+		n.Kind = NodeTableOfContents
+	case blockParagraph:
+		n.Kind = NodeParagraph
+		text := bytes.TrimSuffix(b.text, []byte("\n"))
+		if opts.CommonMark {
+			text = bytes.TrimRight(text, " \t")
+		}
+
+		n.Children = parseSpans(text, b.lines, opts, defs)
+	case blockTable:
+		n.Kind = NodeTable
+		n.Children = tableToNodes(b, opts, defs)
+	default:
+		panic(fmt.Sprint("unrecognized block kind: ", int64(b.kind)))
+	}
+
+	return n
+}
+
+// tableToNodes splits a table into its head and body. Every row gets a cell
+// for each column of the table, dropping extra cells and filling in missing
+// ones.
+func tableToNodes(b *block, opts ParseOptions, defs linkDefinitions) []*Node {
+	rows := bytes.SplitAfter(bytes.TrimSuffix(b.text, []byte("\n")), []byte("\n"))
+
+	head := &Node{Kind: NodeTableHead, Pos: b.lines[0]}
+	body := &Node{Kind: NodeTableBody}
+
+	for i, row := range rows {
+		r := &Node{Kind: NodeTableRow, Pos: b.lines[i]}
+		cells := splitTableRow(row)
+
+		for j, align := range b.align {
+			c := &Node{Kind: NodeTableCell, Pos: r.Pos, Align: align, Children: []*Node{}}
+
+			if j < len(cells) {
+				c.Pos.Column += cells[j].offset
+				c.Children = parseSpans(cells[j].text, []Position{c.Pos}, opts, defs)
+			}
+
+			r.Children = append(r.Children, c)
+		}
+
+		if i == 0 {
+			head.Children = append(head.Children, r)
+		} else {
+			body.Pos = b.lines[1]
+			body.Children = append(body.Children, r)
+		}
+	}
+
+	if len(body.Children) == 0 {
+		return []*Node{head}
+	}
+
+	return []*Node{head, body}
+}
+
+// assignHeadingIDs gives every heading in a document a unique id.
+func assignHeadingIDs(doc *Node) {
+	used := map[string]bool{}
+
+	Walk(doc, func(n *Node) bool {
+		if n.Kind == NodeHeading {
+			n.ID = uniqueSlug(slugify(n.Text()), used)
+		}
+
+		return true
+	})
+}
+
+// slugify turns heading text into an id by lowercasing it, joining words
+// with dashes and dropping punctuation.
+func slugify(text string) string {
+	var slug strings.Builder
+	dash := false
+
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_':
+			if dash && slug.Len() > 0 {
+				slug.WriteByte('-')
+			}
+
+			dash = false
+			slug.WriteRune(r)
+		case r == '-' || unicode.IsSpace(r):
+			dash = true
+		}
+	}
+
+	if slug.Len() == 0 {
+		return "section"
+	}
+
+	return slug.String()
+}
+
+// uniqueSlug numbers repeated slugs so that ids stay unique.
+func uniqueSlug(slug string, used map[string]bool) string {
+	unique := slug
+	for n := 1; used[unique]; n++ {
+		unique = fmt.Sprintf("%s-%d", slug, n)
+	}
+
+	used[unique] = true
+	return unique
+}
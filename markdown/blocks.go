@@ -0,0 +1,1547 @@
+package markdown
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+)
+
+type ast struct {
+	blocks []*block
+}
+
+type block struct {
+	kind     blockKind
+	text     []byte
+	children []*block
+
+	pos   Position   // where the block starts in the source
+	lines []Position // where each line of text starts in the source
+
+	// quotes and lists only, the block their text currently ends in, kept
+	// up to date as lines are added so lazy lines can be checked without
+	// parsing the text again
+	open *block
+
+	// headings only, whether a CommonMark heading was an underlined paragraph
+	setext bool
+
+	// code blocks only
+	fence       []byte
+	info        []byte
+	closed      bool
+	fenceIndent int // spaces before the opening fence, removed from each line
+
+	// list blocks only
+	marker byte // bullet character, or the delimiter after an ordered number
+	start  int  // number of the first item of an ordered list
+	indent int  // columns before the content of the list's last item
+	tight  bool // whether item paragraphs are rendered without <p> tags
+
+	// list items only, whether the item starts with a checkbox
+	task    bool
+	checked bool
+
+	// table blocks only
+	align []string // alignment of each column, empty for the default
+
+	// link definitions only
+	label []byte
+	dest  string
+	title string
+}
+
+type blockKind int64
+
+const (
+	blockBlank blockKind = iota
+	blockH1
+	blockH2
+	blockH3
+	blockH4
+	blockH5
+	blockH6
+	blockThematicBreak // CommonMark only
+	blockQuote
+	blockCode
+	blockIndentedCode // CommonMark only
+	blockUnorderedList
+	blockOrderedList
+	blockTOC
+	blockParagraph
+	blockTable          // only opened by a paragraph
+	blockListItem       // only found inside lists
+	blockLinkDefinition // only split from the start of paragraphs
+)
+
+func (b blockKind) String() string {
+	switch b {
+	case blockBlank:
+		return "BLANK"
+	case blockH1:
+		return "H1"
+	case blockH2:
+		return "H2"
+	case blockH3:
+		return "H3"
+	case blockH4:
+		return "H4"
+	case blockH5:
+		return "H5"
+	case blockH6:
+		return "H6"
+	case blockThematicBreak:
+		return "THEMATIC_BREAK"
+	case blockQuote:
+		return "QUOTE"
+	case blockCode:
+		return "CODE"
+	case blockIndentedCode:
+		return "INDENTED_CODE"
+	case blockUnorderedList:
+		return "UNORDERED_LIST"
+	case blockOrderedList:
+		return "ORDERED_LIST"
+	case blockTOC:
+		return "TOC"
+	case blockParagraph:
+		return "PARAGRAPH"
+	case blockTable:
+		return "TABLE"
+	case blockListItem:
+		return "LIST_ITEM"
+	case blockLinkDefinition:
+		return "LINK_DEFINITION"
+	default:
+		panic(fmt.Sprint("unrecognized block kind: ", int64(b)))
+	}
+}
+
+// parseBlocks splits input into blocks. Positions holds where each line of
+// input starts in the markdown source.
+func parseBlocks(input []byte, positions []Position, opts ParseOptions) (*ast, error) {
+	ast := &ast{blocks: []*block{}}
+
+	lines := bytes.SplitAfter(input, []byte("\n"))
+
+	var current *block
+
+	for i, l := range lines {
+		pos := lineStart(positions, i)
+
+		if current == nil {
+			current = openFirstBlock(l, opts)
+			current.pos = pos
+			trackLine(current, 0, l, pos)
+			ast.blocks = append(ast.blocks, current)
+			continue
+		}
+
+		if canCloseBlock(current, l, opts) {
+			current = openFirstBlock(l, opts)
+			current.pos = pos
+			trackLine(current, 0, l, pos)
+			ast.blocks = append(ast.blocks, current)
+			continue
+		}
+
+		before := len(current.text)
+		continueBlock(current, l, opts)
+		trackLine(current, before, l, pos)
+	}
+
+	ast.blocks = splitLinkDefinitions(ast.blocks, opts)
+
+	for _, b := range ast.blocks {
+		err := finalizeBlock(b, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ast, nil
+}
+
+// finalizeBlock is called once a block is complete, so that container
+// blocks can parse their contents.
+func finalizeBlock(b *block, opts ParseOptions) error {
+	switch b.kind {
+	case blockIndentedCode:
+		finalizeBlockIndentedCode(b)
+	case blockQuote:
+		return finalizeBlockQuote(b, opts)
+	case blockUnorderedList, blockOrderedList:
+		return finalizeBlockList(b, opts)
+	}
+
+	return nil
+}
+
+// checkNesting returns a *ParseError if a quote or list is too deep to parse
+// its contents and the options are strict.
+func checkNesting(b *block, opts ParseOptions) error {
+	if opts.depth < maxNesting || !opts.Strict {
+		return nil
+	}
+
+	return &ParseError{
+		Pos: b.pos,
+		Msg: fmt.Sprintf("quotes and lists can't be nested more than %d deep", maxNesting),
+	}
+}
+
+func lineStart(positions []Position, i int) Position {
+	if i < len(positions) {
+		return positions[i]
+	}
+
+	if len(positions) == 0 {
+		return Position{Line: i + 1, Column: 1}
+	}
+
+	// past the end, which only happens for the empty line after a final newline
+	return Position{Line: positions[len(positions)-1].Line + i - len(positions) + 1, Column: 1}
+}
+
+// trackLine records where a line added to the text of a block came from.
+// Blocks only ever add the end of a line to their text, after their markers.
+func trackLine(b *block, before int, line []byte, pos Position) {
+	added := len(b.text) - before
+	if added <= 0 {
+		return
+	}
+
+	// stripping part of a tab adds spaces that weren't in the line
+	if added > len(line) {
+		added = len(line)
+	}
+
+	pos.Column += len(line) - added
+	b.lines = append(b.lines, pos)
+}
+
+func openFirstBlock(line []byte, opts ParseOptions) *block {
+	for k := blockBlank; k <= blockParagraph; k++ {
+		if canOpenBlock(k, line, opts) {
+			return openBlock(k, line, opts)
+		}
+	}
+
+	panic("could not open any block")
+}
+
+func canOpenBlock(k blockKind, line []byte, opts ParseOptions) bool {
+	switch k {
+	case blockBlank:
+		return canOpenBlockBlank(line)
+	case blockH1:
+		return canOpenBlockH1(line, opts)
+	case blockH2:
+		return canOpenBlockH2(line, opts)
+	case blockH3:
+		return canOpenBlockH3(line, opts)
+	case blockH4:
+		return canOpenBlockH4(line, opts)
+	case blockH5:
+		return canOpenBlockH5(line, opts)
+	case blockH6:
+		return canOpenBlockH6(line, opts)
+	case blockThematicBreak:
+		return canOpenBlockThematicBreak(line, opts)
+	case blockQuote:
+		return canOpenBlockQuote(line, opts)
+	case blockCode:
+		return canOpenBlockCode(line, opts)
+	case blockIndentedCode:
+		return canOpenBlockIndentedCode(line, opts)
+	case blockUnorderedList:
+		return canOpenBlockUnorderedList(line)
+	case blockOrderedList:
+		return canOpenBlockOrderedList(line)
+	case blockTOC:
+		return canOpenBlockTOC(line, opts)
+	case blockParagraph:
+		return canOpenBlockParagraph(line)
+	case blockTable:
+		return false
+	case blockListItem:
+		return false
+	default:
+		panic(fmt.Sprint("unrecognized block kind: ", int64(k)))
+	}
+}
+
+func openBlock(k blockKind, line []byte, opts ParseOptions) *block {
+	switch k {
+	case blockBlank:
+		return openBlockBlank(line)
+	case blockH1:
+		return openBlockH1(line, opts)
+	case blockH2:
+		return openBlockH2(line, opts)
+	case blockH3:
+		return openBlockH3(line, opts)
+	case blockH4:
+		return openBlockH4(line, opts)
+	case blockH5:
+		return openBlockH5(line, opts)
+	case blockH6:
+		return openBlockH6(line, opts)
+	case blockThematicBreak:
+		return openBlockThematicBreak(line)
+	case blockQuote:
+		return openBlockQuote(line, opts)
+	case blockCode:
+		return openBlockCode(line, opts)
+	case blockIndentedCode:
+		return openBlockIndentedCode(line)
+	case blockUnorderedList:
+		return openBlockUnorderedList(line, opts)
+	case blockOrderedList:
+		return openBlockOrderedList(line, opts)
+	case blockTOC:
+		return openBlockTOC(line)
+	case blockParagraph:
+		return openBlockParagraph(line, opts)
+	case blockTable:
+		panic("tables can only be opened by paragraphs")
+	case blockListItem:
+		panic("list items can only be opened by lists")
+	default:
+		panic(fmt.Sprint("unrecognized block kind: ", int64(k)))
+	}
+}
+
+func canCloseBlock(b *block, line []byte, opts ParseOptions) bool {
+	switch b.kind {
+	case blockBlank:
+		return canCloseBlockBlank(b, line)
+	case blockH1:
+		return canCloseBlockH1(b, line)
+	case blockH2:
+		return canCloseBlockH2(b, line)
+	case blockH3:
+		return canCloseBlockH3(b, line)
+	case blockH4:
+		return canCloseBlockH4(b, line)
+	case blockH5:
+		return canCloseBlockH5(b, line)
+	case blockH6:
+		return canCloseBlockH6(b, line)
+	case blockThematicBreak:
+		return canCloseBlockThematicBreak(b, line)
+	case blockQuote:
+		return canCloseBlockQuote(b, line, opts)
+	case blockCode:
+		return canCloseBlockCode(b, line)
+	case blockIndentedCode:
+		return canCloseBlockIndentedCode(b, line)
+	case blockUnorderedList:
+		return canCloseBlockUnorderedList(b, line, opts)
+	case blockOrderedList:
+		return canCloseBlockOrderedList(b, line, opts)
+	case blockTOC:
+		return canCloseBlockTOC(b, line)
+	case blockParagraph:
+		return canCloseBlockParagraph(b, line, opts)
+	case blockTable:
+		return canCloseBlockTable(b, line, opts)
+	case blockListItem:
+		return true
+	default:
+		panic(fmt.Sprint("unrecognized block kind: ", int64(b.kind)))
+	}
+}
+
+func continueBlock(b *block, line []byte, opts ParseOptions) {
+	switch b.kind {
+	case blockBlank:
+		continueBlockBlank(b, line)
+	case blockH1:
+		continueBlockH1(b, line)
+	case blockH2:
+		continueBlockH2(b, line)
+	case blockH3:
+		continueBlockH3(b, line)
+	case blockH4:
+		continueBlockH4(b, line)
+	case blockH5:
+		continueBlockH5(b, line)
+	case blockH6:
+		continueBlockH6(b, line)
+	case blockThematicBreak:
+		continueBlockThematicBreak(b, line)
+	case blockQuote:
+		continueBlockQuote(b, line, opts)
+	case blockCode:
+		continueBlockCode(b, line, opts)
+	case blockIndentedCode:
+		continueBlockIndentedCode(b, line)
+	case blockUnorderedList:
+		continueBlockUnorderedList(b, line, opts)
+	case blockOrderedList:
+		continueBlockOrderedList(b, line, opts)
+	case blockTOC:
+		continueBlockTOC(b, line)
+	case blockParagraph:
+		continueBlockParagraph(b, line, opts)
+	case blockTable:
+		continueBlockTable(b, line)
+	case blockListItem:
+		panic("list items can only be continued by lists")
+	default:
+		panic(fmt.Sprint("unrecognized block kind: ", int64(b.kind)))
+	}
+}
+
+func trimPrefix(buf []byte, prefix *regexp.Regexp) []byte {
+	idx := prefix.FindIndex(buf)
+	if idx == nil {
+		return buf
+	}
+
+	return buf[idx[1]:]
+}
+
+var blankLine = regexp.MustCompile(`^\s*$`)
+
+func lineIsBlank(line []byte) bool {
+	return blankLine.Match(line)
+}
+
+func canOpenBlockBlank(line []byte) bool {
+	return lineIsBlank(line)
+}
+
+func openBlockBlank(line []byte) *block {
+	return &block{
+		kind: blockBlank,
+		text: line,
+	}
+}
+
+func canCloseBlockBlank(b *block, line []byte) bool {
+	return true
+}
+
+func continueBlockBlank(b *block, line []byte) {
+	return
+}
+
+var blockH1Opening = regexp.MustCompile(`^#\s+`)
+
+func canOpenBlockH1(line []byte, opts ParseOptions) bool {
+	if opts.CommonMark {
+		return atxHeadingLevel(line) == 1
+	}
+
+	return blockH1Opening.Match(line)
+}
+
+func openBlockH1(line []byte, opts ParseOptions) *block {
+	if opts.CommonMark {
+		return openATXHeading(blockH1, line)
+	}
+
+	return &block{
+		kind: blockH1,
+		text: trimPrefix(line, blockH1Opening),
+	}
+}
+
+func canCloseBlockH1(b *block, line []byte) bool {
+	return true
+}
+
+func continueBlockH1(b *block, line []byte) {
+	return
+}
+
+var blockH2Opening = regexp.MustCompile(`^##\s+`)
+
+func canOpenBlockH2(line []byte, opts ParseOptions) bool {
+	if opts.CommonMark {
+		return atxHeadingLevel(line) == 2
+	}
+
+	return blockH2Opening.Match(line)
+}
+
+func openBlockH2(line []byte, opts ParseOptions) *block {
+	if opts.CommonMark {
+		return openATXHeading(blockH2, line)
+	}
+
+	return &block{
+		kind: blockH2,
+		text: trimPrefix(line, blockH2Opening),
+	}
+}
+
+func canCloseBlockH2(b *block, line []byte) bool {
+	return true
+}
+
+func continueBlockH2(b *block, line []byte) {
+	return
+}
+
+var blockH3Opening = regexp.MustCompile(`^###\s+`)
+
+func canOpenBlockH3(line []byte, opts ParseOptions) bool {
+	if opts.CommonMark {
+		return atxHeadingLevel(line) == 3
+	}
+
+	return blockH3Opening.Match(line)
+}
+
+func openBlockH3(line []byte, opts ParseOptions) *block {
+	if opts.CommonMark {
+		return openATXHeading(blockH3, line)
+	}
+
+	return &block{
+		kind: blockH3,
+		text: trimPrefix(line, blockH3Opening),
+	}
+}
+
+func canCloseBlockH3(b *block, line []byte) bool {
+	return true
+}
+
+func continueBlockH3(b *block, line []byte) {
+	return
+}
+
+var blockH4Opening = regexp.MustCompile(`^####\s+`)
+
+func canOpenBlockH4(line []byte, opts ParseOptions) bool {
+	if opts.CommonMark {
+		return atxHeadingLevel(line) == 4
+	}
+
+	return blockH4Opening.Match(line)
+}
+
+func openBlockH4(line []byte, opts ParseOptions) *block {
+	if opts.CommonMark {
+		return openATXHeading(blockH4, line)
+	}
+
+	return &block{
+		kind: blockH4,
+		text: trimPrefix(line, blockH4Opening),
+	}
+}
+
+func canCloseBlockH4(b *block, line []byte) bool {
+	return true
+}
+
+func continueBlockH4(b *block, line []byte) {
+	return
+}
+
+var blockH5Opening = regexp.MustCompile(`^#####\s+`)
+
+func canOpenBlockH5(line []byte, opts ParseOptions) bool {
+	if opts.CommonMark {
+		return atxHeadingLevel(line) == 5
+	}
+
+	return blockH5Opening.Match(line)
+}
+
+func openBlockH5(line []byte, opts ParseOptions) *block {
+	if opts.CommonMark {
+		return openATXHeading(blockH5, line)
+	}
+
+	return &block{
+		kind: blockH5,
+		text: trimPrefix(line, blockH5Opening),
+	}
+}
+
+func canCloseBlockH5(b *block, line []byte) bool {
+	return true
+}
+
+func continueBlockH5(b *block, line []byte) {
+	return
+}
+
+var blockH6Opening = regexp.MustCompile(`^######\s+`)
+
+func canOpenBlockH6(line []byte, opts ParseOptions) bool {
+	if opts.CommonMark {
+		return atxHeadingLevel(line) == 6
+	}
+
+	return blockH6Opening.Match(line)
+}
+
+func openBlockH6(line []byte, opts ParseOptions) *block {
+	if opts.CommonMark {
+		return openATXHeading(blockH6, line)
+	}
+
+	return &block{
+		kind: blockH6,
+		text: trimPrefix(line, blockH6Opening),
+	}
+}
+
+func canCloseBlockH6(b *block, line []byte) bool {
+	return true
+}
+
+func continueBlockH6(b *block, line []byte) {
+	return
+}
+
+// atxHeadingOpening matches the start of a CommonMark heading, which may be
+// indented and may be empty.
+var atxHeadingOpening = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+|\n|$)`)
+
+// atxHeadingLevel returns the level of a CommonMark heading, or 0 if line
+// isn't one.
+func atxHeadingLevel(line []byte) int {
+	matches := atxHeadingOpening.FindSubmatch(line)
+	if matches == nil {
+		return 0
+	}
+
+	return len(matches[1])
+}
+
+// openATXHeading opens a CommonMark heading. Any closing sequence of #s is
+// left in its text, to be removed along with trailing whitespace once its
+// position no longer matters.
+func openATXHeading(k blockKind, line []byte) *block {
+	return &block{
+		kind: k,
+		text: trimPrefix(line, atxHeadingOpening),
+	}
+}
+
+var atxHeadingClosing = regexp.MustCompile(`(?:^|[ \t]+)#*[ \t]*$`)
+
+// trimATXHeading removes the optional closing sequence of #s from the text
+// of a CommonMark heading.
+func trimATXHeading(text []byte) []byte {
+	text = bytes.TrimRight(text, " \t\n")
+
+	if idx := atxHeadingClosing.FindIndex(text); idx != nil {
+		text = text[:idx[0]]
+	}
+
+	return text
+}
+
+var thematicBreak = regexp.MustCompile(`^ {0,3}(?:(?:\*[ \t]*){3,}|(?:-[ \t]*){3,}|(?:_[ \t]*){3,})\n?$`)
+
+func canOpenBlockThematicBreak(line []byte, opts ParseOptions) bool {
+	return opts.CommonMark && thematicBreak.Match(line)
+}
+
+func openBlockThematicBreak(line []byte) *block {
+	return &block{
+		kind: blockThematicBreak,
+		text: []byte{},
+	}
+}
+
+func canCloseBlockThematicBreak(b *block, line []byte) bool {
+	return true
+}
+
+func continueBlockThematicBreak(b *block, line []byte) {
+	return
+}
+
+var blockTOCOpening = regexp.MustCompile(`^\[\[_TOC_\]\]\s*$`)
+
+func canOpenBlockTOC(line []byte, opts ParseOptions) bool {
+	return !opts.CommonMark && blockTOCOpening.Match(line)
+}
+
+func openBlockTOC(line []byte) *block {
+	return &block{
+		kind: blockTOC,
+		text: line,
+	}
+}
+
+func canCloseBlockTOC(b *block, line []byte) bool {
+	return true
+}
+
+func continueBlockTOC(b *block, line []byte) {
+	return
+}
+
+var (
+	backtickFence = regexp.MustCompile("^(`{3,})([^`\n]*)\n?$")
+	tildeFence    = regexp.MustCompile(`^(~{3,})([^\n]*)\n?$`)
+	closingFence  = regexp.MustCompile("^(`{3,}|~{3,})[ \t]*\n?$")
+
+	// CommonMark allows fences to be indented by up to three spaces
+	commonMarkBacktickFence = regexp.MustCompile("^( {0,3})(`{3,})([^`\n]*)\n?$")
+	commonMarkTildeFence    = regexp.MustCompile(`^( {0,3})(~{3,})([^\n]*)\n?$`)
+	commonMarkClosingFence  = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})[ \t]*\n?$")
+)
+
+func canOpenBlockCode(line []byte, opts ParseOptions) bool {
+	if opts.CommonMark {
+		return commonMarkBacktickFence.Match(line) || commonMarkTildeFence.Match(line)
+	}
+
+	return backtickFence.Match(line) || tildeFence.Match(line)
+}
+
+func openBlockCode(line []byte, opts ParseOptions) *block {
+	if opts.CommonMark {
+		matches := commonMarkBacktickFence.FindSubmatch(line)
+		if matches == nil {
+			matches = commonMarkTildeFence.FindSubmatch(line)
+		}
+
+		return &block{
+			kind:        blockCode,
+			text:        []byte{},
+			fence:       matches[2],
+			info:        unescapeString(bytes.TrimSpace(matches[3])),
+			fenceIndent: len(matches[1]),
+		}
+	}
+
+	matches := backtickFence.FindSubmatch(line)
+	if matches == nil {
+		matches = tildeFence.FindSubmatch(line)
+	}
+
+	return &block{
+		kind:  blockCode,
+		text:  []byte{},
+		fence: matches[1],
+		info:  bytes.TrimSpace(matches[2]),
+	}
+}
+
+func canCloseBlockCode(b *block, line []byte) bool {
+	return b.closed
+}
+
+func continueBlockCode(b *block, line []byte, opts ParseOptions) {
+	closing := closingFence
+	if opts.CommonMark {
+		closing = commonMarkClosingFence
+	}
+
+	// the closing fence must use the same character and be at least as long
+	if fence := closing.FindSubmatch(line); fence != nil && fence[1][0] == b.fence[0] && len(fence[1]) >= len(b.fence) {
+		b.closed = true
+		return
+	}
+
+	b.text = append(b.text, stripSpaces(line, b.fenceIndent)...)
+}
+
+// stripSpaces removes up to n leading spaces from a line.
+func stripSpaces(line []byte, n int) []byte {
+	i := 0
+	for i < n && i < len(line) && line[i] == ' ' {
+		i++
+	}
+
+	return line[i:]
+}
+
+func canOpenBlockIndentedCode(line []byte, opts ParseOptions) bool {
+	return opts.CommonMark && !lineIsBlank(line) && leadingSpaces(line) >= 4
+}
+
+func openBlockIndentedCode(line []byte) *block {
+	return &block{
+		kind: blockIndentedCode,
+		text: stripIndent(line, 4),
+	}
+}
+
+func canCloseBlockIndentedCode(b *block, line []byte) bool {
+	return !lineIsBlank(line) && leadingSpaces(line) < 4
+}
+
+func continueBlockIndentedCode(b *block, line []byte) {
+	b.text = append(b.text, stripIndent(line, 4)...)
+}
+
+// finalizeBlockIndentedCode drops the blank lines at the end of a code
+// block, which separate it from whatever follows rather than belonging to it.
+func finalizeBlockIndentedCode(b *block) {
+	lines := bytes.SplitAfter(b.text, []byte("\n"))
+
+	for len(lines) > 0 && lineIsBlank(lines[len(lines)-1]) {
+		lines = lines[:len(lines)-1]
+	}
+
+	b.text = bytes.Join(lines, nil)
+}
+
+var (
+	blockQuoteOpening = regexp.MustCompile(`^>\s+`)
+	// only one space after the marker belongs to it, the rest is content
+	blockQuoteMarker = regexp.MustCompile(`^>[ \t]?`)
+
+	// CommonMark quotes may be indented and need no space after the marker
+	commonMarkBlockQuoteMarker = regexp.MustCompile(`^ {0,3}>[ \t]?`)
+)
+
+func canOpenBlockQuote(line []byte, opts ParseOptions) bool {
+	if opts.CommonMark {
+		return commonMarkBlockQuoteMarker.Match(line)
+	}
+
+	return blockQuoteOpening.Match(line)
+}
+
+func openBlockQuote(line []byte, opts ParseOptions) *block {
+	text := trimQuoteMarker(line, opts)
+
+	return &block{
+		kind: blockQuote,
+		text: text,
+		open: continueOpenBlock(nil, text, opts.inside()),
+	}
+}
+
+func trimQuoteMarker(line []byte, opts ParseOptions) []byte {
+	if opts.CommonMark {
+		return trimPrefix(line, commonMarkBlockQuoteMarker)
+	}
+
+	return trimPrefix(line, blockQuoteMarker)
+}
+
+func canCloseBlockQuote(b *block, line []byte, opts ParseOptions) bool {
+	if canOpenBlockQuote(line, opts) {
+		return false
+	}
+
+	if canOpenBlockBlank(line) || canInterruptParagraph(line, opts) {
+		return true
+	}
+
+	// anything else is a lazy continuation, but only of a paragraph
+	return !endsInParagraph(b.open)
+}
+
+func continueBlockQuote(b *block, line []byte, opts ParseOptions) {
+	if canOpenBlockQuote(line, opts) {
+		line = trimQuoteMarker(line, opts)
+	}
+
+	b.text = append(b.text, line...)
+	b.open = continueOpenBlock(b.open, line, opts.inside())
+}
+
+// finalizeBlockQuote parses the contents of a quote as blocks of their own.
+func finalizeBlockQuote(b *block, opts ParseOptions) error {
+	err := checkNesting(b, opts)
+	if err != nil {
+		return err
+	}
+
+	children, err := parseContents(b.text, b.lines, opts.inside())
+	if err != nil {
+		return err
+	}
+
+	b.children = children
+
+	return nil
+}
+
+// parseContents parses the text of a quote or list item as blocks of their
+// own. Past the deepest nesting allowed the text is kept as one paragraph,
+// so the quotes and lists in it are shown as they were written.
+func parseContents(text []byte, lines []Position, opts ParseOptions) ([]*block, error) {
+	if opts.depth <= maxNesting {
+		parsed, err := parseBlocks(text, lines, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		return parsed.blocks, nil
+	}
+
+	for len(text) > 0 {
+		end := bytes.IndexByte(text, '\n') + 1
+		if end == 0 {
+			end = len(text)
+		}
+
+		if !lineIsBlank(text[:end]) {
+			break
+		}
+
+		text = text[end:]
+		if len(lines) > 0 {
+			lines = lines[1:]
+		}
+	}
+
+	text = bytes.TrimRight(text, " \t\n")
+	if len(text) == 0 {
+		return []*block{}, nil
+	}
+
+	return []*block{{kind: blockParagraph, text: text, pos: lineStart(lines, 0), lines: lines}}, nil
+}
+
+// continueOpenBlock adds a line to the text a container's open block is
+// part of, the same way parseBlocks would, and returns the block the text
+// ends in afterwards. Past the deepest nesting allowed the text is a single
+// paragraph, which nothing is kept track of for.
+func continueOpenBlock(open *block, line []byte, opts ParseOptions) *block {
+	if opts.depth > maxNesting {
+		return nil
+	}
+
+	if open == nil || canCloseBlock(open, line, opts) {
+		return openFirstBlock(line, opts)
+	}
+
+	continueBlock(open, line, opts)
+	return open
+}
+
+// endsInParagraph reports whether an open block is a paragraph, either on
+// its own or as the last thing inside a container. Link definitions haven't
+// been split from paragraphs yet, so a paragraph of only link definitions
+// counts too.
+func endsInParagraph(open *block) bool {
+	if open == nil {
+		return false
+	}
+
+	switch open.kind {
+	case blockParagraph:
+		return true
+	case blockQuote, blockUnorderedList, blockOrderedList:
+		return endsInParagraph(open.open)
+	default:
+		return false
+	}
+}
+
+// canInterruptParagraph reports whether a line starts a new block even in the
+// middle of a paragraph.
+func canInterruptParagraph(line []byte, opts ParseOptions) bool {
+	return canOpenBlockH1(line, opts) ||
+		canOpenBlockH2(line, opts) ||
+		canOpenBlockH3(line, opts) ||
+		canOpenBlockH4(line, opts) ||
+		canOpenBlockH5(line, opts) ||
+		canOpenBlockH6(line, opts) ||
+		canOpenBlockThematicBreak(line, opts) ||
+		canOpenBlockCode(line, opts) ||
+		canOpenBlockQuote(line, opts) ||
+		canOpenBlockTOC(line, opts) ||
+		canInterruptParagraphWithList(line)
+}
+
+func canOpenBlockParagraph(line []byte) bool {
+	return true
+}
+
+func openBlockParagraph(line []byte, opts ParseOptions) *block {
+	if opts.CommonMark {
+		// leading whitespace is never part of CommonMark paragraph text
+		line = bytes.TrimLeft(line, " \t")
+	}
+
+	return &block{
+		kind: blockParagraph,
+		text: line,
+	}
+}
+
+// splitLinkDefinitions takes the link definitions from the start of each
+// paragraph, or underlined heading, and makes them blocks of their own. A paragraph that was only
+// link definitions is replaced by them.
+func splitLinkDefinitions(blocks []*block, opts ParseOptions) []*block {
+	out := []*block{}
+
+	for _, b := range blocks {
+		if b.kind != blockParagraph && !b.setext {
+			out = append(out, b)
+			continue
+		}
+
+		for len(b.text) != 0 {
+			label, dest, title, n, ok := parseLinkDefinition(b.text, opts)
+			if !ok {
+				break
+			}
+
+			out = append(out, &block{
+				kind:  blockLinkDefinition,
+				text:  b.text[:n],
+				pos:   b.lines[0],
+				label: label,
+				dest:  dest,
+				title: title,
+			})
+
+			b.lines = b.lines[bytes.Count(b.text[:n], []byte("\n")):]
+			b.text = b.text[n:]
+		}
+
+		if len(b.text) != 0 {
+			b.pos = b.lines[0]
+			out = append(out, b)
+		}
+	}
+
+	return out
+}
+
+func onlyLinkDefinitions(text []byte, opts ParseOptions) bool {
+	for len(text) != 0 {
+		_, _, _, n, ok := parseLinkDefinition(text, opts)
+		if !ok {
+			return false
+		}
+
+		text = text[n:]
+	}
+
+	return true
+}
+
+var linkDefinitionLabel = regexp.MustCompile(`^ {0,3}\[((?:[^\[\]\\]|\\.){0,999})\]:`)
+
+// parseLinkDefinition parses a link definition like [label]: /url "title" at
+// the start of text, returning the length of the lines it takes up. The
+// destination and title are unescaped like those of inline links, so for the
+// wiki's dialect they're taken as they are.
+func parseLinkDefinition(text []byte, opts ParseOptions) (label []byte, dest, title string, n int, ok bool) {
+	matches := linkDefinitionLabel.FindSubmatch(text)
+	if matches == nil || len(bytes.TrimSpace(matches[1])) == 0 {
+		return nil, "", "", 0, false
+	}
+
+	pos := skipLinkSpace(text, len(matches[0]))
+	angled := pos < len(text) && text[pos] == '<'
+
+	// only a destination in angle brackets can be empty
+	rawDest, pos, ok := parseLinkDestination(text, pos)
+	if !ok || (len(rawDest) == 0 && !angled) {
+		return nil, "", "", 0, false
+	}
+
+	// the title is optional, but nothing else may follow on its line
+	var rawTitle []byte
+	end, ok := lineEnd(text, pos)
+
+	if next := skipLinkSpace(text, pos); next > pos {
+		if t, next, found := parseLinkTitle(text, next); found {
+			if e, atEnd := lineEnd(text, next); atEnd {
+				rawTitle, end, ok = t, e, true
+			}
+		}
+	}
+
+	if !ok {
+		return nil, "", "", 0, false
+	}
+
+	if opts.CommonMark {
+		return matches[1], normalizeURI(unescapeString(rawDest)), string(unescapeString(rawTitle)), end, true
+	}
+
+	u, err := url.Parse(string(rawDest))
+	if err != nil {
+		return nil, "", "", 0, false
+	}
+
+	return matches[1], u.String(), string(rawTitle), end, true
+}
+
+// lineEnd reports whether there is only whitespace between pos and the end
+// of its line, and where the next line starts.
+func lineEnd(text []byte, pos int) (int, bool) {
+	for ; pos < len(text); pos++ {
+		switch text[pos] {
+		case ' ', '\t':
+			continue
+		case '\n':
+			return pos + 1, true
+		default:
+			return pos, false
+		}
+	}
+
+	return pos, true
+}
+
+var setextHeadingUnderline = regexp.MustCompile(`^ {0,3}(=+|-+)[ \t]*\n?$`)
+
+func canCloseBlockParagraph(b *block, line []byte, opts ParseOptions) bool {
+	if opts.CommonMark && setextHeadingUnderline.Match(line) {
+		return false
+	}
+
+	return canOpenBlockBlank(line) || canInterruptParagraph(line, opts)
+}
+
+func continueBlockParagraph(b *block, line []byte, opts ParseOptions) {
+	if opts.CommonMark {
+		// a CommonMark paragraph can be underlined to make it a heading, as
+		// long as there's more to it than link definitions
+		if matches := setextHeadingUnderline.FindSubmatch(line); matches != nil && !onlyLinkDefinitions(b.text, opts) {
+			b.kind = blockH2
+			if matches[1][0] == '=' {
+				b.kind = blockH1
+			}
+
+			b.setext = true
+
+			return
+		}
+
+		b.text = append(b.text, bytes.TrimLeft(line, " \t")...)
+		return
+	}
+
+	// a paragraph of a single row followed by a delimiter row is really the
+	// header of a table
+	if align, ok := parseTableDelimiterRow(b.text, line); ok {
+		b.kind = blockTable
+		b.align = align
+		return
+	}
+
+	b.text = append(b.text, line...)
+}
+
+var tableDelimiterCell = regexp.MustCompile(`^:?-+:?$`)
+
+// parseTableDelimiterRow checks that line is a delimiter row like
+// "| :--- | :---: |" with a cell for every cell of header, and returns the
+// alignment of each column.
+func parseTableDelimiterRow(header, line []byte) ([]string, bool) {
+	// checked for every line of a paragraph, so only look past the end of
+	// the first line if it's the only one
+	if bytes.IndexByte(header, '\n') != len(header)-1 || !bytes.Contains(header, []byte("|")) || !bytes.Contains(line, []byte("|")) {
+		return nil, false
+	}
+
+	cells := splitTableRow(line)
+	if len(cells) == 0 || len(cells) != len(splitTableRow(header)) {
+		return nil, false
+	}
+
+	align := make([]string, len(cells))
+	for i, cell := range cells {
+		c := cell.text
+		if !tableDelimiterCell.Match(c) {
+			return nil, false
+		}
+
+		left, right := c[0] == ':', c[len(c)-1] == ':'
+
+		switch {
+		case left && right:
+			align[i] = "center"
+		case left:
+			align[i] = "left"
+		case right:
+			align[i] = "right"
+		}
+	}
+
+	return align, true
+}
+
+type tableCell struct {
+	text   []byte
+	offset int // where the cell's text starts in the row
+}
+
+// splitTableRow splits a table row into its trimmed cells. Pipes at the start
+// and end of the row are optional, and an escaped pipe is part of a cell.
+func splitTableRow(line []byte) []tableCell {
+	indent := len(line) - len(bytes.TrimLeft(line, " \t"))
+	line = bytes.TrimSpace(line)
+
+	cells := []tableCell{}
+	cell := []byte{}
+	start := 0
+	trailing := false
+
+	// trimCell drops the whitespace around the text of a cell
+	trimCell := func() tableCell {
+		leading := len(cell) - len(bytes.TrimLeft(cell, " \t"))
+		return tableCell{text: bytes.TrimSpace(cell), offset: indent + start + leading}
+	}
+
+	for i := 0; i < len(line); i++ {
+		trailing = false
+
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell = append(cell, '|')
+			i++
+		case line[i] == '|':
+			cells = append(cells, trimCell())
+			cell = []byte{}
+			start = i + 1
+			trailing = true
+		default:
+			cell = append(cell, line[i])
+		}
+	}
+
+	cells = append(cells, trimCell())
+
+	if len(line) != 0 && line[0] == '|' {
+		cells = cells[1:]
+	}
+
+	if trailing && len(cells) != 0 {
+		cells = cells[:len(cells)-1]
+	}
+
+	return cells
+}
+
+func canCloseBlockTable(b *block, line []byte, opts ParseOptions) bool {
+	return canCloseBlockParagraph(b, line, opts)
+}
+
+func continueBlockTable(b *block, line []byte) {
+	b.text = append(b.text, line...)
+}
+
+var (
+	bulletListMarker  = regexp.MustCompile(`^( {0,3})([-*+])([ \t]*)`)
+	orderedListMarker = regexp.MustCompile(`^( {0,3})([0-9]{1,9})([.)])([ \t]*)`)
+)
+
+type listMarker struct {
+	ordered bool
+	marker  byte
+	number  int
+	width   int // bytes of the line taken up by the marker
+	indent  int // columns before the item's content
+}
+
+func parseListMarker(line []byte) (listMarker, bool) {
+	m := listMarker{}
+	var spaces []byte
+
+	if matches := bulletListMarker.FindSubmatch(line); matches != nil {
+		m.marker = matches[2][0]
+		m.width = len(matches[0]) - len(matches[3])
+		spaces = matches[3]
+	} else if matches := orderedListMarker.FindSubmatch(line); matches != nil {
+		m.ordered = true
+		m.marker = matches[3][0]
+		m.number, _ = strconv.Atoi(string(matches[2]))
+		m.width = len(matches[0]) - len(matches[4])
+		spaces = matches[4]
+	} else {
+		return m, false
+	}
+
+	rest := line[m.width+len(spaces):]
+
+	switch {
+	case lineIsBlank(rest):
+		// an empty item, its content goes on the following lines
+		m.width += len(spaces)
+		m.indent = m.width - len(spaces) + 1
+	case len(spaces) == 0:
+		// the marker must be followed by whitespace
+		return m, false
+	case len(spaces) > 4:
+		// only one space belongs to the marker, the rest is content
+		m.width++
+		m.indent = m.width
+	default:
+		m.width += len(spaces)
+		m.indent = m.width
+	}
+
+	return m, true
+}
+
+// canInterruptParagraphWithList reports whether a line starts a list even in
+// the middle of a paragraph. Ordered lists must start at 1 to do so, since
+// lines like "1999. was a good year" are common in prose.
+func canInterruptParagraphWithList(line []byte) bool {
+	m, ok := parseListMarker(line)
+	if !ok || lineIsBlank(line[m.width:]) {
+		return false
+	}
+
+	return !m.ordered || m.number == 1
+}
+
+// leadingSpaces counts the columns of whitespace a line starts with, with
+// tabs counting as four.
+func leadingSpaces(line []byte) int {
+	n := 0
+	for _, c := range line {
+		if c == ' ' {
+			n++
+		} else if c == '\t' {
+			n += 4
+		} else {
+			break
+		}
+	}
+
+	return n
+}
+
+// stripIndent removes up to n columns of leading whitespace from a line.
+func stripIndent(line []byte, n int) []byte {
+	cols := 0
+	for i, c := range line {
+		if cols >= n {
+			return line[i:]
+		}
+
+		if c == ' ' {
+			cols++
+		} else if c == '\t' {
+			cols += 4
+			if cols > n {
+				return append(bytes.Repeat([]byte(" "), cols-n), line[i+1:]...)
+			}
+		} else {
+			return line[i:]
+		}
+	}
+
+	return line[len(line):]
+}
+
+// endsWithBlankLine reports whether the last line of text is blank. It only
+// looks at the last line, since it's called for every line added to a list.
+func endsWithBlankLine(text []byte) bool {
+	if len(text) == 0 {
+		return false
+	}
+
+	start := bytes.LastIndexByte(text[:len(text)-1], '\n') + 1
+
+	return lineIsBlank(text[start:])
+}
+
+func canOpenBlockUnorderedList(line []byte) bool {
+	m, ok := parseListMarker(line)
+	return ok && !m.ordered
+}
+
+func openBlockUnorderedList(line []byte, opts ParseOptions) *block {
+	return openBlockList(blockUnorderedList, line, opts)
+}
+
+func canCloseBlockUnorderedList(b *block, line []byte, opts ParseOptions) bool {
+	return canCloseBlockList(b, line, opts)
+}
+
+func continueBlockUnorderedList(b *block, line []byte, opts ParseOptions) {
+	continueBlockList(b, line, opts)
+}
+
+func canOpenBlockOrderedList(line []byte) bool {
+	m, ok := parseListMarker(line)
+	return ok && m.ordered
+}
+
+func openBlockOrderedList(line []byte, opts ParseOptions) *block {
+	return openBlockList(blockOrderedList, line, opts)
+}
+
+func canCloseBlockOrderedList(b *block, line []byte, opts ParseOptions) bool {
+	return canCloseBlockList(b, line, opts)
+}
+
+func continueBlockOrderedList(b *block, line []byte, opts ParseOptions) {
+	continueBlockList(b, line, opts)
+}
+
+func openBlockList(k blockKind, line []byte, opts ParseOptions) *block {
+	m, _ := parseListMarker(line)
+
+	return &block{
+		kind:   k,
+		text:   line,
+		marker: m.marker,
+		start:  m.number,
+		indent: m.indent,
+		open:   continueOpenBlock(nil, line[m.width:], opts.inside()),
+	}
+}
+
+func canCloseBlockList(b *block, line []byte, opts ParseOptions) bool {
+	if lineIsBlank(line) || leadingSpaces(line) >= b.indent {
+		return false
+	}
+
+	// "* * *" is a thematic break rather than an item
+	if canOpenBlockThematicBreak(line, opts) {
+		return true
+	}
+
+	if m, ok := parseListMarker(line); ok {
+		// another item of the same list
+		return m.ordered != (b.kind == blockOrderedList) || m.marker != b.marker
+	}
+
+	if endsWithBlankLine(b.text) {
+		return true
+	}
+
+	// anything else is a lazy continuation of the last item's paragraph
+	return canInterruptParagraph(line, opts)
+}
+
+// continueBlockList adds a line to a list, keeping track of the open block
+// of its last item the same way finalizeBlockList splits items.
+func continueBlockList(b *block, line []byte, opts ParseOptions) {
+	b.text = append(b.text, line...)
+
+	if !lineIsBlank(line) && leadingSpaces(line) < b.indent {
+		if m, ok := parseListMarker(line); ok {
+			b.indent = m.indent
+			b.open = continueOpenBlock(nil, line[m.width:], opts.inside())
+			return
+		}
+	}
+
+	b.open = continueOpenBlock(b.open, stripIndent(line, b.indent), opts.inside())
+}
+
+// finalizeBlockList splits a list into its items and parses the contents of
+// each item as blocks of their own.
+func finalizeBlockList(b *block, opts ParseOptions) error {
+	err := checkNesting(b, opts)
+	if err != nil {
+		return err
+	}
+
+	var item *block
+	indent := 0
+
+	for i, l := range bytes.SplitAfter(b.text, []byte("\n")) {
+		if len(l) == 0 {
+			continue
+		}
+
+		pos := lineStart(b.lines, i)
+
+		if item == nil || (!lineIsBlank(l) && leadingSpaces(l) < indent) {
+			if m, ok := parseListMarker(l); ok {
+				item = &block{kind: blockListItem, pos: pos}
+				item.text = l[m.width:]
+				trackLine(item, 0, l, pos)
+				b.children = append(b.children, item)
+				indent = m.indent
+				continue
+			}
+		}
+
+		// either indented into the item or a lazy continuation
+		before := len(item.text)
+		item.text = append(item.text, stripIndent(l, indent)...)
+		trackLine(item, before, l, pos)
+	}
+
+	loose := false
+
+	for i, item := range b.children {
+		children, err := parseContents(item.text, item.lines, opts.inside())
+		if err != nil {
+			return err
+		}
+
+		item.children = children
+
+		// an empty item's first line is blank, but doesn't end the item
+		content := item.text[bytes.IndexByte(item.text, '\n')+1:]
+		if i < len(b.children)-1 && endsWithBlankLine(content) {
+			loose = true
+		}
+
+		if hasBlankBetweenChildren(item) {
+			loose = true
+		}
+	}
+
+	b.tight = !loose
+
+	for _, item := range b.children {
+		item.tight = b.tight
+
+		if !opts.CommonMark {
+			takeTaskMarker(item)
+		}
+	}
+	return nil
+}
+
+var taskMarker = regexp.MustCompile(`^\[([ xX])\][ \t]`)
+
+// takeTaskMarker makes an item whose text starts with [ ] or [x] a task,
+// removing the marker from the start of its first paragraph. The paragraph
+// still starts where the marker did.
+func takeTaskMarker(item *block) {
+	if len(item.children) == 0 || item.children[0].kind != blockParagraph {
+		return
+	}
+
+	p := item.children[0]
+
+	matches := taskMarker.FindSubmatch(p.text)
+	if matches == nil {
+		return
+	}
+
+	item.task = true
+	item.checked = matches[1][0] != ' '
+
+	p.text = p.text[len(matches[0]):]
+	p.lines[0].Column += len(matches[0])
+}
+
+func hasBlankBetweenChildren(b *block) bool {
+	seenContent := false
+	blank := false
+
+	for _, c := range b.children {
+		if c.kind == blockBlank {
+			blank = true
+			continue
+		}
+
+		if seenContent && blank {
+			return true
+		}
+
+		seenContent = true
+		blank = (c.kind == blockUnorderedList || c.kind == blockOrderedList) && endsWithBlankLine(c.text)
+	}
+
+	return false
+}
@@ -0,0 +1,450 @@
+package markdown
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+)
+
+// consumeDelimiterRun handles a run of * or _ in CommonMark. Whether the run
+// can open or close emphasis depends on the characters around it.
+func consumeDelimiterRun(sl *spanList, ds *delimiterStack, input []byte, pos int) int {
+	c := input[pos]
+
+	end := pos
+	for end < len(input) && input[end] == c {
+		end++
+	}
+
+	// the start and end of the text count as whitespace
+	before, after := '\n', '\n'
+	if pos > 0 {
+		before, _ = utf8.DecodeLastRune(input[:pos])
+	}
+
+	if end < len(input) {
+		after, _ = utf8.DecodeRune(input[end:])
+	}
+
+	leftFlanking := !unicode.IsSpace(after) && (!isPunct(after) || unicode.IsSpace(before) || isPunct(before))
+	rightFlanking := !unicode.IsSpace(before) && (!isPunct(before) || unicode.IsSpace(after) || isPunct(after))
+
+	kind := delimiterAsterisk
+	canOpen := leftFlanking
+	canClose := rightFlanking
+
+	// underscores can't emphasize part of a word
+	if c == '_' {
+		kind = delimiterUnderscore
+		canOpen = leftFlanking && (!rightFlanking || isPunct(before))
+		canClose = rightFlanking && (!leftFlanking || isPunct(after))
+	}
+
+	span := sl.push(input[pos:end], pos)
+
+	if canOpen || canClose {
+		ds.push(&delimiter{
+			kind:     kind,
+			span:     span,
+			canOpen:  canOpen,
+			canClose: canClose,
+			run:      end - pos,
+		})
+	}
+
+	return end
+}
+
+func isPunct(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+func isASCIIPunct(c byte) bool {
+	return c < utf8.RuneSelf && isPunct(rune(c))
+}
+
+type openersBottomKey struct {
+	kind    delimiterKind
+	canOpen bool
+	runMod3 int
+}
+
+// processEmphasisCommonMark matches the runs of * and _ above bottom in the
+// delimiter stack as described by the CommonMark spec, then removes every
+// delimiter above bottom. Runs are used two at a time for strong emphasis
+// where possible.
+func processEmphasisCommonMark(sl *spanList, ds *delimiterStack, bottom *delimiter) {
+	// where the search for an opener for each kind of closer gave up last time
+	openersBottom := map[openersBottomKey]*delimiter{}
+
+	closer := ds.begin
+	if bottom != nil {
+		closer = bottom.next
+	}
+
+	for closer != nil {
+		if !closer.canClose || (closer.kind != delimiterAsterisk && closer.kind != delimiterUnderscore) {
+			closer = closer.next
+			continue
+		}
+
+		key := openersBottomKey{kind: closer.kind, canOpen: closer.canOpen, runMod3: closer.run % 3}
+
+		var opener *delimiter
+		for d := closer.prev; d != nil && d != bottom && d != openersBottom[key]; d = d.prev {
+			if d.kind == closer.kind && d.canOpen && !oddMatch(d, closer) {
+				opener = d
+				break
+			}
+		}
+
+		if opener == nil {
+			openersBottom[key] = closer.prev
+
+			next := closer.next
+			if !closer.canOpen {
+				ds.rm(closer)
+			}
+
+			closer = next
+			continue
+		}
+
+		use, kind := 1, NodeEmph
+		if len(opener.span.text) >= 2 && len(closer.span.text) >= 2 {
+			use, kind = 2, NodeStrong
+		}
+
+		opener.span.text = opener.span.text[:len(opener.span.text)-use]
+		closer.span.text = closer.span.text[use:]
+		closer.span.offset += use
+
+		sl.insertAfter(opener.span, &span{
+			kind:   spanOpen,
+			node:   &Node{Kind: kind},
+			offset: opener.span.offset + len(opener.span.text),
+		})
+
+		sl.insertBefore(closer.span, &span{
+			kind:   spanClose,
+			offset: closer.span.offset - use,
+		})
+
+		for d := opener.next; d != closer; d = d.next {
+			ds.rm(d)
+		}
+
+		if len(opener.span.text) == 0 {
+			ds.rm(opener)
+		}
+
+		if len(closer.span.text) == 0 {
+			next := closer.next
+			ds.rm(closer)
+			closer = next
+		}
+	}
+
+	for ds.end != nil && ds.end != bottom {
+		ds.rm(ds.end)
+	}
+}
+
+// oddMatch reports whether a pair of runs can't match because of the "rule
+// of 3": if either could open and close, their lengths can't add up to a
+// multiple of 3 unless both are multiples of 3.
+func oddMatch(opener, closer *delimiter) bool {
+	return (opener.canClose || closer.canOpen) &&
+		(opener.run+closer.run)%3 == 0 &&
+		(opener.run%3 != 0 || closer.run%3 != 0)
+}
+
+// consumeCloseBracketCommonMark finishes a link or image if the closest [ or
+// ![ is followed by a destination in parentheses or refers to a link
+// definition. Links can't contain other links, so once one is made the
+// brackets before it can no longer open one.
+func consumeCloseBracketCommonMark(sl *spanList, ds *delimiterStack, input []byte, pos int, opts ParseOptions, defs linkDefinitions) int {
+	span := sl.push([]byte(`]`), pos)
+
+	var opener *delimiter
+	for d := ds.end; d != nil; d = d.prev {
+		if d.kind == delimiterOpenBracket || d.kind == delimiterImageBracket {
+			opener = d
+			break
+		}
+	}
+
+	if opener == nil {
+		return pos + 1
+	}
+
+	if !opener.canOpen {
+		ds.rm(opener)
+		return pos + 1
+	}
+
+	dest, title, end, ok := parseInlineLink(input, pos+1)
+	if !ok {
+		var def *block
+		if def, end, ok = referenceLink(input, opener, pos, defs); ok {
+			dest, title = def.dest, def.title
+		}
+	}
+
+	if !ok || !allowedURL(dest, opts) {
+		ds.rm(opener)
+		return pos + 1
+	}
+
+	processEmphasisCommonMark(sl, ds, opener)
+
+	kind := NodeImage
+	if opener.kind == delimiterOpenBracket {
+		kind = NodeLink
+
+		for d := opener.prev; d != nil; d = d.prev {
+			if d.kind == delimiterOpenBracket && d.canOpen {
+				d.canOpen = false
+				ds.openBrackets--
+			}
+		}
+	}
+
+	ds.rm(opener)
+
+	opener.span.kind = spanOpen
+	opener.span.node = &Node{
+		Kind:        kind,
+		Destination: dest,
+		Title:       title,
+	}
+
+	span.kind = spanClose
+	return end
+}
+
+// parseInlineLink parses the parenthesized destination and optional title
+// that follow the text of an inline link, starting at pos. It returns where
+// the link ends.
+func parseInlineLink(input []byte, pos int) (dest, title string, end int, ok bool) {
+	if pos >= len(input) || input[pos] != '(' {
+		return "", "", 0, false
+	}
+
+	pos = skipLinkSpace(input, pos+1)
+
+	rawDest, pos, ok := parseLinkDestination(input, pos)
+	if !ok {
+		return "", "", 0, false
+	}
+
+	var rawTitle []byte
+
+	// the title must be separated from the destination
+	if next := skipLinkSpace(input, pos); next > pos {
+		pos = next
+
+		if t, next, ok := parseLinkTitle(input, pos); ok {
+			rawTitle = t
+			pos = skipLinkSpace(input, next)
+		}
+	}
+
+	if pos >= len(input) || input[pos] != ')' {
+		return "", "", 0, false
+	}
+
+	return normalizeURI(unescapeString(rawDest)), string(unescapeString(rawTitle)), pos + 1, true
+}
+
+// skipLinkSpace skips the whitespace allowed around a link's destination and
+// title, which may include a line ending.
+func skipLinkSpace(input []byte, pos int) int {
+	for pos < len(input) && (input[pos] == ' ' || input[pos] == '\t' || input[pos] == '\n') {
+		pos++
+	}
+
+	return pos
+}
+
+// maxLinkParens limits how deeply parentheses can nest in a link
+// destination.
+const maxLinkParens = 32
+
+// parseLinkDestination parses either a destination in angle brackets, which
+// may contain spaces, or a bare one with balanced parentheses. The
+// destination may be empty.
+func parseLinkDestination(input []byte, pos int) ([]byte, int, bool) {
+	if pos < len(input) && input[pos] == '<' {
+		for i := pos + 1; i < len(input); i++ {
+			switch input[i] {
+			case '\\':
+				if i+1 < len(input) && isASCIIPunct(input[i+1]) {
+					i++
+				}
+			case '\n', '<':
+				return nil, pos, false
+			case '>':
+				return input[pos+1 : i], i + 1, true
+			}
+		}
+
+		return nil, pos, false
+	}
+
+	depth := 0
+	i := pos
+
+loop:
+	for ; i < len(input); i++ {
+		c := input[i]
+
+		switch {
+		case c == '\\' && i+1 < len(input) && isASCIIPunct(input[i+1]):
+			i++
+		case c <= ' ' || c == 0x7f:
+			break loop
+		case c == '(':
+			depth++
+			if depth > maxLinkParens {
+				return nil, pos, false
+			}
+		case c == ')':
+			if depth == 0 {
+				break loop
+			}
+
+			depth--
+		}
+	}
+
+	if depth != 0 {
+		return nil, pos, false
+	}
+
+	return input[pos:i], i, true
+}
+
+// parseLinkTitle parses a title in double quotes, single quotes or
+// parentheses.
+func parseLinkTitle(input []byte, pos int) ([]byte, int, bool) {
+	if pos >= len(input) {
+		return nil, pos, false
+	}
+
+	var closer byte
+
+	switch input[pos] {
+	case '"':
+		closer = '"'
+	case '\'':
+		closer = '\''
+	case '(':
+		closer = ')'
+	default:
+		return nil, pos, false
+	}
+
+	for i := pos + 1; i < len(input); i++ {
+		switch {
+		case input[i] == '\\' && i+1 < len(input) && isASCIIPunct(input[i+1]):
+			i++
+		case input[i] == closer:
+			return input[pos+1 : i], i + 1, true
+		case closer == ')' && input[i] == '(':
+			return nil, pos, false
+		}
+	}
+
+	return nil, pos, false
+}
+
+var entityMatcher = regexp.MustCompile(`^&(?:#[xX][0-9a-fA-F]{1,6}|#[0-9]{1,7}|[A-Za-z][A-Za-z0-9]{1,31});`)
+
+// decodeEntity returns the text an HTML entity or numeric character
+// reference at the start of input stands for, and how long it is.
+func decodeEntity(input []byte) ([]byte, int, bool) {
+	ref := entityMatcher.Find(input)
+	if ref == nil {
+		return nil, 0, false
+	}
+
+	decoded := html.UnescapeString(string(ref))
+	if decoded == string(ref) {
+		// not an entity HTML knows about
+		return nil, 0, false
+	}
+
+	return []byte(decoded), len(ref), true
+}
+
+func consumeEntity(sl *spanList, input []byte, pos int) int {
+	decoded, n, ok := decodeEntity(input[pos:])
+	if !ok {
+		sl.push([]byte(`&`), pos)
+		return pos + 1
+	}
+
+	// the decoded text is never formatting
+	sl.push(decoded, pos)
+	return pos + n
+}
+
+// unescapeString resolves backslash escapes and entities in text that isn't
+// otherwise formatted, like link destinations and titles.
+func unescapeString(text []byte) []byte {
+	out := []byte{}
+
+	for i := 0; i < len(text); i++ {
+		switch {
+		case text[i] == '\\' && i+1 < len(text) && isASCIIPunct(text[i+1]):
+			out = append(out, text[i+1])
+			i++
+		case text[i] == '&':
+			decoded, n, ok := decodeEntity(text[i:])
+			if !ok {
+				out = append(out, '&')
+				continue
+			}
+
+			out = append(out, decoded...)
+			i += n - 1
+		default:
+			out = append(out, text[i])
+		}
+	}
+
+	return out
+}
+
+// normalizeURI percent-encodes the characters of a link destination that
+// aren't allowed in a URL, leaving existing escapes alone.
+func normalizeURI(uri []byte) string {
+	var b bytes.Buffer
+
+	for i := 0; i < len(uri); i++ {
+		c := uri[i]
+
+		switch {
+		case c == '%' && i+2 < len(uri) && isHex(uri[i+1]) && isHex(uri[i+2]):
+			b.WriteByte(c)
+		case c < utf8.RuneSelf && (isAlphanumeric(c) || bytes.IndexByte([]byte(";/?:@&=+$,-_.!~*'()#"), c) != -1):
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func isAlphanumeric(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -0,0 +1,119 @@
+package markdown
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// testdata/commonmark_spec.json holds the examples from version 0.31.2 of the
+// CommonMark spec (https://spec.commonmark.org/0.31.2/), as dumped by the
+// spec's own test runner. The spec is licensed under CC-BY-SA 4.0.
+type specExample struct {
+	Markdown string `json:"markdown"`
+	HTML     string `json:"html"`
+	Example  int    `json:"example"`
+	Section  string `json:"section"`
+}
+
+var (
+	specEntity   = regexp.MustCompile(`&(?:#[xX][0-9a-fA-F]+|#[0-9]+|[A-Za-z][A-Za-z0-9]*);`)
+	specVoidTags = regexp.MustCompile(`<(br|hr|img)([^>]*?)\s*/>`)
+)
+
+// normalizeSpecHTML smooths over differences between our HTML and the spec's
+// that a browser wouldn't notice: how characters are escaped and whether
+// empty elements are closed with />.
+func normalizeSpecHTML(in string) string {
+	out := specEntity.ReplaceAllStringFunc(in, func(ref string) string {
+		switch s := html.UnescapeString(ref); s {
+		case "<":
+			return "&lt;"
+		case ">":
+			return "&gt;"
+		case "&":
+			return "&amp;"
+		case `"`:
+			return "&quot;"
+		default:
+			return s
+		}
+	})
+
+	return specVoidTags.ReplaceAllString(out, "<$1$2>")
+}
+
+func renderCommonMark(input string) (string, error) {
+	doc, err := ParseWithOptions([]byte(input), ParseOptions{CommonMark: true})
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+
+	err = HTMLRenderer{}.Render(&buf, doc)
+	if err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// TestCommonMarkSpec runs every example in the spec and compares how many
+// pass in each section with testdata/commonmark_spec.out. CommonMark mode
+// doesn't pass every example, the report tracks which ones it does so that
+// changes in conformance show up. When conformance changes on purpose the
+// report should be updated to match.
+func TestCommonMarkSpec(t *testing.T) {
+	var examples []specExample
+
+	err := json.Unmarshal(mustReadFixture("commonmark_spec.json"), &examples)
+	if err != nil {
+		t.Fatalf("could not decode spec examples: %s", err.Error())
+	}
+
+	sections := []string{}
+	total := map[string]int{}
+	failing := map[string][]string{}
+	passed := 0
+
+	for _, ex := range examples {
+		if _, ok := total[ex.Section]; !ok {
+			sections = append(sections, ex.Section)
+		}
+
+		total[ex.Section]++
+
+		out, err := renderCommonMark(ex.Markdown)
+		if err == nil && normalizeSpecHTML(out) == normalizeSpecHTML(ex.HTML) {
+			passed++
+			continue
+		}
+
+		failing[ex.Section] = append(failing[ex.Section], fmt.Sprint(ex.Example))
+	}
+
+	report := new(strings.Builder)
+
+	for _, section := range sections {
+		n := total[section] - len(failing[section])
+		fmt.Fprintf(report, "%s: %d/%d (%d%%)\n", section, n, total[section], 100*n/total[section])
+
+		if len(failing[section]) != 0 {
+			fmt.Fprintf(report, "  failing: %s\n", strings.Join(failing[section], " "))
+		}
+	}
+
+	fmt.Fprintf(report, "total: %d/%d (%d%%)\n", passed, len(examples), 100*passed/len(examples))
+
+	t.Logf("CommonMark conformance:\n%s", report)
+
+	expected := mustReadFixture("commonmark_spec.out")
+	if !bytes.Equal([]byte(report.String()), expected) {
+		t.Fatalf("conformance changed, diff:\n%s\n", lineDiff(expected, []byte(report.String())))
+	}
+}
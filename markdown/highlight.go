@@ -0,0 +1,149 @@
+package markdown
+
+import (
+	"bytes"
+	"regexp"
+	"unicode/utf8"
+)
+
+// highlightRule marks text matching pattern with a CSS class. If the pattern
+// has a capture group only the group is marked and the rest of the match is
+// plain text.
+type highlightRule struct {
+	pattern *regexp.Regexp
+	class   string
+}
+
+// highlighter tokenizes code by trying each of its rules in order at the
+// current position. Text no rule matches is left plain.
+type highlighter []highlightRule
+
+func rule(class, pattern string) highlightRule {
+	return highlightRule{
+		pattern: regexp.MustCompile(`^(?:` + pattern + `)`),
+		class:   class,
+	}
+}
+
+func (h highlighter) highlight(code []byte) []byte {
+	var buf bytes.Buffer
+	plain := []byte{}
+
+	flushPlain := func() {
+		buf.Write(escapeHTMLBytes(plain))
+		plain = []byte{}
+	}
+
+	pos := 0
+
+outer:
+	for pos < len(code) {
+		for _, r := range h {
+			loc := r.pattern.FindSubmatchIndex(code[pos:])
+			if loc == nil || loc[1] == 0 {
+				continue
+			}
+
+			start, end := 0, loc[1]
+			if len(loc) > 2 && loc[2] != -1 {
+				start, end = loc[2], loc[3]
+			}
+
+			plain = append(plain, code[pos:pos+start]...)
+
+			if r.class == "" {
+				plain = append(plain, code[pos+start:pos+end]...)
+			} else {
+				flushPlain()
+				buf.WriteString(`<span class="` + r.class + `">`)
+				buf.Write(escapeHTMLBytes(code[pos+start : pos+end]))
+				buf.WriteString(`</span>`)
+			}
+
+			plain = append(plain, code[pos+end:pos+loc[1]]...)
+			pos += loc[1]
+
+			continue outer
+		}
+
+		_, size := utf8.DecodeRune(code[pos:])
+		plain = append(plain, code[pos:pos+size]...)
+		pos += size
+	}
+
+	flushPlain()
+
+	return buf.Bytes()
+}
+
+const (
+	hlComment = "hl-comment"
+	hlString  = "hl-string"
+	hlNumber  = "hl-number"
+	hlKeyword = "hl-keyword"
+	hlBuiltin = "hl-builtin"
+	hlLiteral = "hl-literal"
+	hlKey     = "hl-key"
+	hlVar     = "hl-variable"
+)
+
+const number = `-?\b[0-9]+(?:\.[0-9]+)?(?:[eE][+-]?[0-9]+)?\b`
+
+var goHighlighter = highlighter{
+	rule(hlComment, `//[^\n]*|/\*[\s\S]*?\*/`),
+	rule(hlString, `"(?:[^"\\\n]|\\.)*"|`+"`[^`]*`"+`|'(?:[^'\\\n]|\\.)*'`),
+	rule(hlKeyword, `\b(?:break|case|chan|const|continue|default|defer|else|fallthrough|for|func|go|goto|if|import|interface|map|package|range|return|select|struct|switch|type|var)\b`),
+	rule(hlLiteral, `\b(?:true|false|nil|iota)\b`),
+	rule(hlBuiltin, `\b(?:any|bool|byte|complex64|complex128|error|float32|float64|int|int8|int16|int32|int64|rune|string|uint|uint8|uint16|uint32|uint64|uintptr|append|cap|close|complex|copy|delete|imag|len|make|new|panic|print|println|real|recover)\b`),
+	rule(hlNumber, `\b(?:0[xX][0-9a-fA-F_]+|[0-9][0-9_]*(?:\.[0-9_]*)?(?:[eE][+-]?[0-9]+)?)\b`),
+	rule("", `[A-Za-z_][A-Za-z0-9_]*`),
+}
+
+var shellHighlighter = highlighter{
+	rule(hlString, `"(?:[^"\\]|\\.)*"|'[^']*'`),
+	rule(hlVar, `\$(?:\{[^}\n]*\}|[A-Za-z_][A-Za-z0-9_]*|[0-9@#?$!*-])`),
+	rule(hlComment, `#[^\n]*`),
+	rule(hlKeyword, `\b(?:if|then|else|elif|fi|for|while|until|do|done|case|esac|in|function|select|return|export|local)\b`),
+	rule(hlBuiltin, `\b(?:alias|cd|echo|eval|exec|exit|printf|read|set|shift|source|test|trap|unset)\b`),
+	rule("", `[^\s"'$;|&<>()`+"`"+`]+`),
+}
+
+var jsonHighlighter = highlighter{
+	rule(hlKey, `("(?:[^"\\\n]|\\.)*")\s*:`),
+	rule(hlString, `"(?:[^"\\\n]|\\.)*"`),
+	rule(hlLiteral, `\b(?:true|false|null)\b`),
+	rule(hlNumber, number),
+}
+
+var sqlHighlighter = highlighter{
+	rule(hlComment, `--[^\n]*|/\*[\s\S]*?\*/`),
+	rule(hlString, `'(?:[^']|'')*'`),
+	rule("", `"[^"]*"`),
+	rule(hlKeyword, `(?i)\b(?:add|all|alter|and|as|asc|begin|between|by|case|check|commit|constraint|create|cross|default|delete|desc|distinct|drop|else|end|exists|foreign|from|full|grant|group|having|if|in|index|inner|insert|into|is|join|key|left|like|limit|not|offset|on|or|order|outer|primary|references|returning|revoke|right|rollback|select|set|table|then|transaction|trigger|union|unique|update|values|view|when|where|with)\b`),
+	rule(hlLiteral, `(?i)\b(?:null|true|false)\b`),
+	rule(hlBuiltin, `(?i)\b(?:avg|bigint|boolean|char|coalesce|count|date|decimal|float|int|integer|max|min|now|numeric|real|serial|smallint|sum|text|timestamp|varchar)\b`),
+	rule(hlNumber, number),
+	rule("", `[A-Za-z_][A-Za-z0-9_]*`),
+}
+
+var yamlHighlighter = highlighter{
+	rule(hlComment, `#[^\n]*`),
+	rule(hlString, `"(?:[^"\\\n]|\\.)*"|'(?:[^'\n]|'')*'`),
+	rule(hlKey, `([A-Za-z0-9_][^:\n#]*):(?:[ \t\n]|$)`),
+	rule(hlLiteral, `\b(?:true|false|yes|no|on|off|null)\b|~`),
+	rule(hlNumber, number),
+	rule("", `[^\s:"']+`),
+}
+
+var highlighters = map[string]highlighter{
+	"go":     goHighlighter,
+	"golang": goHighlighter,
+	"sh":     shellHighlighter,
+	"bash":   shellHighlighter,
+	"shell":  shellHighlighter,
+	"zsh":    shellHighlighter,
+	"json":   jsonHighlighter,
+	"sql":    sqlHighlighter,
+	"yaml":   yamlHighlighter,
+	"yml":    yamlHighlighter,
+}
@@ -0,0 +1,463 @@
+package markdown
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"strings"
+)
+
+// Renderer writes a parsed document in some output format.
+type Renderer interface {
+	Render(w io.Writer, doc *Node) error
+}
+
+// HTMLRenderer renders documents as HTML. Links resolves [[Title]] links, if
+// it is nil every article is assumed to exist.
+type HTMLRenderer struct {
+	Links LinkResolver
+
+	// ToggleTasks renders the checkboxes of task list items as buttons
+	// instead of disabled checkboxes. Each submits the line its checkbox is
+	// on as "task", for a form around the document to toggle.
+	ToggleTasks bool
+}
+
+type htmlContext struct {
+	links       LinkResolver
+	toggleTasks bool
+
+	// the document's top level headings, for tables of contents
+	headings []*Node
+}
+
+// Render writes doc as HTML. It returns an error if doc has nodes where they
+// can't be rendered, which only happens to trees that weren't parsed, or if
+// writing to w fails.
+func (r HTMLRenderer) Render(w io.Writer, doc *Node) (err error) {
+	if doc == nil {
+		return fmt.Errorf("there is no document to render")
+	}
+
+	err = checkNode(doc)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		// a checked document only fails to render if the renderer has a bug
+		if r := recover(); r != nil {
+			err = fmt.Errorf("could not render markdown: %v", r)
+		}
+	}()
+
+	ew := &errWriter{w: w}
+	w = ew
+
+	ctx := &htmlContext{links: r.Links, toggleTasks: r.ToggleTasks}
+	if ctx.links == nil {
+		ctx.links = articleLinks{}
+	}
+
+	for _, n := range doc.Children {
+		if n.Kind == NodeHeading {
+			ctx.headings = append(ctx.headings, n)
+		}
+	}
+
+	writeNodeToHTML(doc, w, ctx)
+
+	return ew.err
+}
+
+// errWriter keeps the first error from writing to w and skips any writes
+// after it, so that writing HTML doesn't have to check every write.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errWriter) Write(p []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	n, err := e.w.Write(p)
+	e.err = err
+
+	return n, err
+}
+
+// checkNode returns an error for the first node of a tree that can't be
+// rendered: one of an unknown kind, or a part of a table that isn't where
+// it belongs.
+func checkNode(n *Node) error {
+	if n.Kind < NodeDocument || n.Kind > NodeLineBreak {
+		return fmt.Errorf("%s: unrecognized node kind: %d", n.Pos, int64(n.Kind))
+	}
+
+	for _, c := range n.Children {
+		if c == nil {
+			return fmt.Errorf("%s: %s has a nil child", n.Pos, n.Kind)
+		}
+
+		if c.Kind >= NodeDocument && c.Kind <= NodeLineBreak && !canContain(n.Kind, c.Kind) {
+			return fmt.Errorf("%s: %s can't be inside %s", c.Pos, c.Kind, n.Kind)
+		}
+
+		err := checkNode(c)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// canContain reports whether a node of kind parent may have a child of kind
+// child. Tables are made of heads and bodies, of rows, of cells, and their
+// parts can't be anywhere else.
+func canContain(parent, child NodeKind) bool {
+	switch parent {
+	case NodeTable:
+		return child == NodeTableHead || child == NodeTableBody
+	case NodeTableHead, NodeTableBody:
+		return child == NodeTableRow
+	case NodeTableRow:
+		return child == NodeTableCell
+	}
+
+	switch child {
+	case NodeDocument, NodeTableHead, NodeTableBody, NodeTableRow, NodeTableCell:
+		return false
+	default:
+		return true
+	}
+}
+
+func writeNodeToHTML(n *Node, w io.Writer, ctx *htmlContext) {
+	switch n.Kind {
+	case NodeDocument:
+		writeNodesToHTML(n.Children, w, ctx)
+	case NodeHeading:
+		writeNodeHeadingToHTML(n, w, ctx)
+	case NodeThematicBreak:
+		fmt.Fprintf(w, "<hr>\n")
+	case NodeBlockQuote:
+		writeNodeBlockQuoteToHTML(n, w, ctx)
+	case NodeCodeBlock:
+		writeNodeCodeBlockToHTML(n, w, ctx)
+	case NodeUnorderedList:
+		writeNodeUnorderedListToHTML(n, w, ctx)
+	case NodeOrderedList:
+		writeNodeOrderedListToHTML(n, w, ctx)
+	case NodeListItem:
+		writeNodeListItemToHTML(n, w, ctx)
+	case NodeTableOfContents:
+		writeNodeTableOfContentsToHTML(n, w, ctx)
+	case NodeParagraph:
+		writeNodeParagraphToHTML(n, w, ctx)
+	case NodeTable:
+		writeNodeTableToHTML(n, w, ctx)
+	case NodeTableHead, NodeTableBody, NodeTableRow, NodeTableCell:
+		panic("parts of tables can only be written by tables")
+	case NodeText:
+		w.Write(escapeHTMLBytes(n.Literal))
+	case NodeCode:
+		writeNodeCodeToHTML(n, w, ctx)
+	case NodeEmph:
+		writeNodeEmphToHTML(n, w, ctx)
+	case NodeStrong:
+		writeNodeStrongToHTML(n, w, ctx)
+	case NodeStrikethrough:
+		writeNodeStrikethroughToHTML(n, w, ctx)
+	case NodeLink:
+		writeNodeLinkToHTML(n, w, ctx)
+	case NodeImage:
+		writeNodeImageToHTML(n, w, ctx)
+	case NodeWikiLink:
+		writeNodeWikiLinkToHTML(n, w, ctx)
+	case NodeLineBreak:
+		fmt.Fprintf(w, "<br>\n")
+	default:
+		panic(fmt.Sprint("unrecognized node kind: ", int64(n.Kind)))
+	}
+}
+
+func writeNodesToHTML(nodes []*Node, w io.Writer, ctx *htmlContext) {
+	for _, n := range nodes {
+		writeNodeToHTML(n, w, ctx)
+	}
+}
+
+// writeNodeHeadingToHTML writes a heading with a link to itself, if it has
+// an id to link to.
+func writeNodeHeadingToHTML(n *Node, w io.Writer, ctx *htmlContext) {
+	if n.ID == "" {
+		fmt.Fprintf(w, "<h%d>", n.Level)
+		writeNodesToHTML(n.Children, w, ctx)
+		fmt.Fprintf(w, "</h%d>\n", n.Level)
+		return
+	}
+
+	fmt.Fprintf(w, "<h%d id=\"%s\">", n.Level, html.EscapeString(n.ID))
+	writeNodesToHTML(n.Children, w, ctx)
+	fmt.Fprintf(w, " <a class=\"anchor\" href=\"#%s\">&para;</a></h%d>\n", html.EscapeString(n.ID), n.Level)
+}
+
+// writeNodeTableOfContentsToHTML writes the document's top level headings as
+// nested lists, a level deeper for each smaller heading.
+func writeNodeTableOfContentsToHTML(n *Node, w io.Writer, ctx *htmlContext) {
+	if len(ctx.headings) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "<nav class=\"toc\">\n")
+
+	levels := []int{}
+
+	for _, h := range ctx.headings {
+		for len(levels) > 0 && levels[len(levels)-1] > h.Level {
+			fmt.Fprintf(w, "</li>\n</ul>\n")
+			levels = levels[:len(levels)-1]
+		}
+
+		if len(levels) > 0 && levels[len(levels)-1] == h.Level {
+			fmt.Fprintf(w, "</li>\n")
+		} else {
+			if len(levels) > 0 {
+				fmt.Fprintf(w, "\n")
+			}
+
+			fmt.Fprintf(w, "<ul>\n")
+			levels = append(levels, h.Level)
+		}
+
+		fmt.Fprintf(w, "<li><a href=\"#%s\">", html.EscapeString(h.ID))
+		writeNodesToHTML(h.Children, w, ctx)
+		fmt.Fprintf(w, "</a>")
+	}
+
+	for range levels {
+		fmt.Fprintf(w, "</li>\n</ul>\n")
+	}
+
+	fmt.Fprintf(w, "</nav>\n")
+}
+
+func writeNodeBlockQuoteToHTML(n *Node, w io.Writer, ctx *htmlContext) {
+	fmt.Fprintf(w, "<blockquote>\n")
+
+	writeNodesToHTML(n.Children, w, ctx)
+
+	fmt.Fprintf(w, "</blockquote>\n")
+}
+
+func writeNodeCodeBlockToHTML(n *Node, w io.Writer, ctx *htmlContext) {
+	lang := n.Language()
+
+	if lang == "" {
+		fmt.Fprintf(w, "<pre><code>")
+	} else {
+		fmt.Fprintf(w, "<pre><code class=\"language-%s\">", html.EscapeString(lang))
+	}
+
+	if h, ok := highlighters[strings.ToLower(lang)]; ok {
+		w.Write(h.highlight(n.Literal))
+	} else {
+		w.Write(escapeHTMLBytes(n.Literal))
+	}
+
+	fmt.Fprintf(w, "</code></pre>\n")
+}
+
+func writeNodeParagraphToHTML(n *Node, w io.Writer, ctx *htmlContext) {
+	fmt.Fprintf(w, "<p>")
+
+	writeNodesToHTML(n.Children, w, ctx)
+
+	fmt.Fprintf(w, "</p>\n")
+}
+
+func writeNodeTableToHTML(n *Node, w io.Writer, ctx *htmlContext) {
+	fmt.Fprintf(w, "<table>\n")
+
+	for _, part := range n.Children {
+		tag, cellTag := "tbody", "td"
+		if part.Kind == NodeTableHead {
+			tag, cellTag = "thead", "th"
+		}
+
+		fmt.Fprintf(w, "<%s>\n", tag)
+
+		for _, row := range part.Children {
+			writeTableRowToHTML(row, cellTag, w, ctx)
+		}
+
+		fmt.Fprintf(w, "</%s>\n", tag)
+	}
+
+	fmt.Fprintf(w, "</table>\n")
+}
+
+func writeTableRowToHTML(row *Node, tag string, w io.Writer, ctx *htmlContext) {
+	fmt.Fprintf(w, "<tr>\n")
+
+	for _, cell := range row.Children {
+		if cell.Align == "" {
+			fmt.Fprintf(w, "<%s>", tag)
+		} else {
+			fmt.Fprintf(w, "<%s align=\"%s\">", tag, cell.Align)
+		}
+
+		writeNodesToHTML(cell.Children, w, ctx)
+
+		fmt.Fprintf(w, "</%s>\n", tag)
+	}
+
+	fmt.Fprintf(w, "</tr>\n")
+}
+
+func writeNodeUnorderedListToHTML(n *Node, w io.Writer, ctx *htmlContext) {
+	fmt.Fprintf(w, "<ul>\n")
+
+	writeNodesToHTML(n.Children, w, ctx)
+
+	fmt.Fprintf(w, "</ul>\n")
+}
+
+func writeNodeOrderedListToHTML(n *Node, w io.Writer, ctx *htmlContext) {
+	if n.Start == 1 {
+		fmt.Fprintf(w, "<ol>\n")
+	} else {
+		fmt.Fprintf(w, "<ol start=\"%d\">\n", n.Start)
+	}
+
+	writeNodesToHTML(n.Children, w, ctx)
+
+	fmt.Fprintf(w, "</ol>\n")
+}
+
+func writeNodeListItemToHTML(n *Node, w io.Writer, ctx *htmlContext) {
+	if !n.Task {
+		fmt.Fprintf(w, "<li>")
+	} else {
+		fmt.Fprintf(w, "<li class=\"task\">")
+	}
+
+	for i, c := range n.Children {
+		if i == 0 && n.Task {
+			// the checkbox goes at the start of the first paragraph
+			if !n.Tight {
+				fmt.Fprintf(w, "\n<p>")
+			}
+
+			writeTaskCheckboxToHTML(n, w, ctx)
+			writeNodesToHTML(c.Children, w, ctx)
+
+			if !n.Tight {
+				fmt.Fprintf(w, "</p>\n")
+			} else if i < len(n.Children)-1 {
+				fmt.Fprintf(w, "\n")
+			}
+
+			continue
+		}
+
+		if n.Tight && c.Kind == NodeParagraph {
+			// paragraphs in tight lists aren't wrapped in <p>
+			writeNodesToHTML(c.Children, w, ctx)
+
+			if i < len(n.Children)-1 {
+				fmt.Fprintf(w, "\n")
+			}
+
+			continue
+		}
+
+		if i == 0 {
+			fmt.Fprintf(w, "\n")
+		}
+
+		writeNodeToHTML(c, w, ctx)
+	}
+
+	fmt.Fprintf(w, "</li>\n")
+}
+
+// writeTaskCheckboxToHTML writes the checkbox of a task list item. The
+// button for toggling it shows a checkbox character, a button can't contain
+// an actual checkbox.
+func writeTaskCheckboxToHTML(n *Node, w io.Writer, ctx *htmlContext) {
+	switch {
+	case ctx.toggleTasks && n.Checked:
+		fmt.Fprintf(w, "<button type=\"submit\" class=\"task-toggle\" name=\"task\" value=\"%d\" title=\"Mark as not done\">&#9745;</button> ", n.Children[0].Pos.Line)
+	case ctx.toggleTasks:
+		fmt.Fprintf(w, "<button type=\"submit\" class=\"task-toggle\" name=\"task\" value=\"%d\" title=\"Mark as done\">&#9744;</button> ", n.Children[0].Pos.Line)
+	case n.Checked:
+		fmt.Fprintf(w, "<input type=\"checkbox\" checked disabled> ")
+	default:
+		fmt.Fprintf(w, "<input type=\"checkbox\" disabled> ")
+	}
+}
+
+func writeNodeCodeToHTML(n *Node, w io.Writer, ctx *htmlContext) {
+	fmt.Fprintf(w, "<code>")
+	w.Write(escapeHTMLBytes(n.Literal))
+	fmt.Fprintf(w, "</code>")
+}
+
+func writeNodeEmphToHTML(n *Node, w io.Writer, ctx *htmlContext) {
+	fmt.Fprintf(w, "<em>")
+	writeNodesToHTML(n.Children, w, ctx)
+	fmt.Fprintf(w, "</em>")
+}
+
+func writeNodeStrongToHTML(n *Node, w io.Writer, ctx *htmlContext) {
+	fmt.Fprintf(w, "<strong>")
+	writeNodesToHTML(n.Children, w, ctx)
+	fmt.Fprintf(w, "</strong>")
+}
+
+func writeNodeStrikethroughToHTML(n *Node, w io.Writer, ctx *htmlContext) {
+	fmt.Fprintf(w, "<del>")
+	writeNodesToHTML(n.Children, w, ctx)
+	fmt.Fprintf(w, "</del>")
+}
+
+func writeNodeLinkToHTML(n *Node, w io.Writer, ctx *htmlContext) {
+	fmt.Fprintf(w, "<a href=\"%s\"%s>", html.EscapeString(n.Destination), titleAttribute(n.Title))
+	writeNodesToHTML(n.Children, w, ctx)
+	fmt.Fprintf(w, "</a>")
+}
+
+// writeNodeImageToHTML writes an image, its children are only used as the
+// plain text of the alt attribute.
+func writeNodeImageToHTML(n *Node, w io.Writer, ctx *htmlContext) {
+	fmt.Fprintf(w, "<img src=\"%s\" alt=\"%s\"%s>", html.EscapeString(n.Destination), html.EscapeString(n.Text()), titleAttribute(n.Title))
+}
+
+func writeNodeWikiLinkToHTML(n *Node, w io.Writer, ctx *htmlContext) {
+	href, exists := ctx.links.ResolveLink(n.Target)
+
+	class := ""
+	if !exists {
+		class = ` class="new-article"`
+	}
+
+	fmt.Fprintf(w, "<a href=\"%s\"%s>", html.EscapeString(href), class)
+	writeNodesToHTML(n.Children, w, ctx)
+	fmt.Fprintf(w, "</a>")
+}
+
+func titleAttribute(title string) string {
+	if title == "" {
+		return ""
+	}
+
+	return fmt.Sprintf(" title=\"%s\"", html.EscapeString(title))
+}
+
+func escapeHTMLBytes(in []byte) []byte {
+	return []byte(html.EscapeString(string(in)))
+}
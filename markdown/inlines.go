@@ -0,0 +1,1044 @@
+package markdown
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type spanKind int64
+
+const (
+	spanText   spanKind = iota
+	spanInline          // an inline that is already complete, like a code span
+	spanOpen            // the start of an inline containing the spans up to its spanClose
+	spanClose
+)
+
+type span struct {
+	kind   spanKind
+	text   []byte
+	node   *Node
+	offset int // where the span starts in the text being parsed
+	prev   *span
+	next   *span
+}
+
+type spanList struct {
+	begin *span
+	end   *span
+}
+
+func (s *spanList) push(text []byte, offset int) *span {
+	if s.begin == nil && s.end == nil {
+		sp := &span{
+			kind:   spanText,
+			text:   text,
+			offset: offset,
+			prev:   nil,
+			next:   nil,
+		}
+
+		s.begin = sp
+		s.end = sp
+
+		return sp
+	}
+
+	sp := &span{
+		kind:   spanText,
+		text:   text,
+		offset: offset,
+		prev:   s.end,
+		next:   nil,
+	}
+
+	s.end.next = sp
+	s.end = sp
+
+	return sp
+}
+
+// insertAfter adds sp to the list just after at.
+func (s *spanList) insertAfter(at, sp *span) {
+	sp.prev = at
+	sp.next = at.next
+
+	if at.next == nil {
+		s.end = sp
+	} else {
+		at.next.prev = sp
+	}
+
+	at.next = sp
+}
+
+// insertBefore adds sp to the list just before at.
+func (s *spanList) insertBefore(at, sp *span) {
+	sp.next = at
+	sp.prev = at.prev
+
+	if at.prev == nil {
+		s.begin = sp
+	} else {
+		at.prev.next = sp
+	}
+
+	at.prev = sp
+}
+
+func (s *spanList) pushInline(n *Node, offset int) *span {
+	sp := s.push(nil, offset)
+	sp.kind = spanInline
+	sp.node = n
+
+	return sp
+}
+
+func (s *spanList) pop() *span {
+	if s.begin == nil && s.end == nil {
+		panic("pop on empty list")
+	}
+
+	sp := s.end
+
+	if s.begin == s.end {
+		s.begin = nil
+		s.end = nil
+	} else {
+		sp.prev.next = nil
+		s.end = sp.prev
+	}
+
+	return sp
+}
+
+// nodes builds the inlines the spans make up. Adjacent text is merged into
+// a single text node.
+func (s *spanList) nodes(src *source) []*Node {
+	root := &Node{}
+	stack := []*Node{root}
+
+	for sp := s.begin; sp != nil; sp = sp.next {
+		parent := stack[len(stack)-1]
+
+		switch sp.kind {
+		case spanText:
+			if len(sp.text) == 0 {
+				continue
+			}
+
+			if n := len(parent.Children); n > 0 && parent.Children[n-1].Kind == NodeText {
+				parent.Children[n-1].Literal = append(parent.Children[n-1].Literal, sp.text...)
+				continue
+			}
+
+			parent.Children = append(parent.Children, &Node{
+				Kind:    NodeText,
+				Pos:     src.position(sp.offset),
+				Literal: append([]byte{}, sp.text...),
+			})
+		case spanInline:
+			sp.node.Pos = src.position(sp.offset)
+			parent.Children = append(parent.Children, sp.node)
+		case spanOpen:
+			sp.node.Pos = src.position(sp.offset)
+			parent.Children = append(parent.Children, sp.node)
+			stack = append(stack, sp.node)
+		case spanClose:
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+
+	return root.Children
+}
+
+// source maps offsets in the text of a block back to positions in the
+// markdown it came from.
+type source struct {
+	starts []int      // offset of the start of each line of text
+	lines  []Position // position of the start of each line of text
+}
+
+func newSource(text []byte, lines []Position) *source {
+	src := &source{starts: []int{0}, lines: lines}
+
+	for i, c := range text {
+		if c == '\n' {
+			src.starts = append(src.starts, i+1)
+		}
+	}
+
+	return src
+}
+
+func (s *source) position(offset int) Position {
+	i := sort.SearchInts(s.starts, offset+1) - 1
+	if i < 0 || i >= len(s.lines) {
+		return Position{}
+	}
+
+	return Position{Line: s.lines[i].Line, Column: s.lines[i].Column + offset - s.starts[i]}
+}
+
+type delimiterKind int64
+
+const (
+	delimiterAsterisk delimiterKind = iota
+	delimiterUnderscore
+	delimiterOpenBracket
+	delimiterImageBracket
+	delimiterTilde
+)
+
+func (d delimiterKind) String() string {
+	switch d {
+	case delimiterAsterisk:
+		return "ASTERISK"
+	case delimiterUnderscore:
+		return "UNDERSCORE"
+	case delimiterOpenBracket:
+		return "OPEN_BRACKET"
+	case delimiterImageBracket:
+		return "IMAGE_BRACKET"
+	case delimiterTilde:
+		return "TILDE"
+	default:
+		panic(fmt.Sprint("unrecognized delimiter kind: ", int64(d)))
+	}
+}
+
+type delimiter struct {
+	kind     delimiterKind
+	span     *span
+	canOpen  bool
+	canClose bool
+	run      int // CommonMark only, the length of the run of * or _ before any was used
+	prev     *delimiter
+	next     *delimiter
+}
+
+func (d delimiter) String() string {
+	return fmt.Sprintf("kind: %s span: %p canOpen: %t canClose: %t prev: %p next: %p", d.kind, d.span, d.canOpen, d.canClose, d.prev, d.next)
+}
+
+type delimiterStack struct {
+	begin *delimiter
+	end   *delimiter
+
+	// how many of the delimiters are brackets that can still open a link, so
+	// checking for one doesn't need to look through the stack
+	openBrackets int
+}
+
+// opensLink reports whether a delimiter is a [ or ![ that can still become
+// the start of a link or image.
+func opensLink(delim *delimiter) bool {
+	return (delim.kind == delimiterOpenBracket || delim.kind == delimiterImageBracket) && delim.canOpen
+}
+
+func (d *delimiterStack) push(delim *delimiter) {
+	if opensLink(delim) {
+		d.openBrackets++
+	}
+
+	if d.begin == nil && d.end == nil {
+		d.begin = delim
+		d.end = delim
+
+		delim.prev = nil
+		delim.next = nil
+		return
+	}
+
+	d.end.next = delim
+	delim.prev = d.end
+	delim.next = nil
+	d.end = delim
+}
+
+func (d *delimiterStack) truncateAt(delim *delimiter) {
+	for rest := delim.next; rest != nil; rest = rest.next {
+		if opensLink(rest) {
+			d.openBrackets--
+		}
+	}
+
+	d.end = delim
+	delim.next = nil
+}
+
+func (d *delimiterStack) rm(delim *delimiter) {
+	if opensLink(delim) {
+		d.openBrackets--
+	}
+
+	if delim == d.begin {
+		d.begin = delim.next
+	} else {
+		delim.prev.next = delim.next
+	}
+
+	if delim == d.end {
+		d.end = delim.prev
+	} else {
+		delim.next.prev = delim.prev
+	}
+}
+
+// parseSpans parses the inlines in the text of a block. Lines holds the
+// position each line of input starts at in the markdown source.
+func parseSpans(input []byte, lines []Position, opts ParseOptions, defs linkDefinitions) []*Node {
+	src := newSource(input, lines)
+	pos := 0
+	buf := []byte{}
+	sl := &spanList{
+		begin: nil,
+		end:   nil,
+	}
+
+	ds := &delimiterStack{
+		begin: nil,
+		end:   nil,
+	}
+
+	for pos < len(input) {
+		str := []byte{input[pos]}
+
+		switch {
+		case opts.CommonMark && (bytes.Equal(str, []byte(`*`)) || bytes.Equal(str, []byte(`_`))):
+			if len(buf) != 0 {
+				sl.push(buf, pos-len(buf))
+				buf = []byte{}
+			}
+
+			pos = consumeDelimiterRun(sl, ds, input, pos)
+		case opts.CommonMark && bytes.Equal(str, []byte(`&`)):
+			if len(buf) != 0 {
+				sl.push(buf, pos-len(buf))
+				buf = []byte{}
+			}
+
+			pos = consumeEntity(sl, input, pos)
+		case bytes.Equal(str, []byte("\n")) && bytes.HasSuffix(buf, []byte("  ")):
+			// two or more spaces at the end of a line are a hard line break
+			trimmed := bytes.TrimRight(buf, " ")
+			sl.push(trimmed, pos-len(buf))
+			sl.pushInline(&Node{Kind: NodeLineBreak}, pos-len(buf)+len(trimmed))
+			buf = []byte{}
+			pos++
+		case opts.CommonMark && bytes.Equal(str, []byte("\n")):
+			// spaces at the end of a line are dropped
+			if len(buf) != 0 {
+				sl.push(bytes.TrimRight(buf, " "), pos-len(buf))
+				buf = []byte{}
+			}
+
+			buf = append(buf, '\n')
+			pos++
+		case !opts.CommonMark && bytes.Equal(str, []byte(`~`)):
+			if len(buf) != 0 {
+				sl.push(buf, pos-len(buf))
+				buf = []byte{}
+			}
+
+			pos = consumeTilde(sl, ds, input, pos)
+		case bytes.Equal(str, []byte(`<`)) && (opts.CommonMark || !inLinkText(ds)) && isAutolink(input[pos:]):
+			if len(buf) != 0 {
+				sl.push(buf, pos-len(buf))
+				buf = []byte{}
+			}
+
+			pos = consumeAutolink(sl, src, input, pos, opts)
+		case !opts.CommonMark && canOpenBareURL(ds, input, pos):
+			if len(buf) != 0 {
+				sl.push(buf, pos-len(buf))
+				buf = []byte{}
+			}
+
+			pos = consumeBareURL(sl, src, input, pos, opts)
+		case bytes.Equal(str, []byte(`*`)):
+			if len(buf) != 0 {
+				sl.push(buf, pos-len(buf))
+				buf = []byte{}
+			}
+
+			pos = consumeAsterisk(sl, ds, input, pos)
+		case bytes.Equal(str, []byte(`_`)):
+			if len(buf) != 0 {
+				sl.push(buf, pos-len(buf))
+				buf = []byte{}
+			}
+
+			pos = consumeUnderscore(sl, ds, input, pos)
+		case !opts.CommonMark && bytes.Equal(str, []byte(`[`)) && wikiLinkMatcher.Match(input[pos:]):
+			if len(buf) != 0 {
+				sl.push(buf, pos-len(buf))
+				buf = []byte{}
+			}
+
+			pos = consumeWikiLink(sl, src, input, pos, opts, defs)
+		case bytes.Equal(str, []byte(`[`)):
+			if len(buf) != 0 {
+				sl.push(buf, pos-len(buf))
+				buf = []byte{}
+			}
+
+			pos = consumeOpenBracket(sl, ds, input, pos)
+		case bytes.Equal(str, []byte(`!`)) && pos+1 < len(input) && input[pos+1] == '[':
+			if len(buf) != 0 {
+				sl.push(buf, pos-len(buf))
+				buf = []byte{}
+			}
+
+			pos = consumeImageBracket(sl, ds, input, pos)
+		case bytes.Equal(str, []byte(`]`)):
+			if len(buf) != 0 {
+				sl.push(buf, pos-len(buf))
+				buf = []byte{}
+			}
+
+			if opts.CommonMark {
+				pos = consumeCloseBracketCommonMark(sl, ds, input, pos, opts, defs)
+			} else {
+				pos = consumeCloseBracket(sl, ds, input, pos, opts, defs)
+			}
+		case bytes.Equal(str, []byte(`\`)):
+			if len(buf) != 0 {
+				sl.push(buf, pos-len(buf))
+				buf = []byte{}
+			}
+
+			pos = consumeBackslash(sl, input, pos, opts)
+		case bytes.Equal(str, []byte("`")):
+			if len(buf) != 0 {
+				sl.push(buf, pos-len(buf))
+				buf = []byte{}
+			}
+
+			pos = consumeBacktick(sl, input, pos)
+		default:
+			buf = append(buf, input[pos])
+			pos++
+		}
+	}
+
+	if len(buf) != 0 {
+		sl.push(buf, pos-len(buf))
+	}
+
+	if opts.CommonMark {
+		processEmphasisCommonMark(sl, ds, nil)
+	} else {
+		processEmphasis(ds)
+	}
+
+	return sl.nodes(src)
+}
+
+var (
+	openingAsteriskMatcher = regexp.MustCompile(`\*[^\s]`)
+	closingAsteriskMatcher = regexp.MustCompile(`[^\s]\*`)
+)
+
+func consumeAsterisk(sl *spanList, ds *delimiterStack, input []byte, pos int) int {
+	span := sl.push([]byte(`*`), pos)
+
+	canOpen := false
+	canClose := false
+
+	if pos > 0 {
+		canClose = closingAsteriskMatcher.Match(input[pos-1 : pos+1])
+	}
+
+	if pos+1 < len(input) {
+		canOpen = openingAsteriskMatcher.Match(input[pos : pos+2])
+	}
+
+	if canOpen || canClose {
+		ds.push(&delimiter{
+			kind:     delimiterAsterisk,
+			span:     span,
+			canOpen:  canOpen,
+			canClose: canClose,
+		})
+	}
+
+	return pos + 1
+}
+
+var (
+	openingUnderscoreMatcher = regexp.MustCompile(`_[^\s]`)
+	closingUnderscoreMatcher = regexp.MustCompile(`[^\s]_`)
+)
+
+func consumeUnderscore(sl *spanList, ds *delimiterStack, input []byte, pos int) int {
+	span := sl.push([]byte(`_`), pos)
+
+	canOpen := false
+	canClose := false
+
+	if pos > 0 {
+		canClose = closingUnderscoreMatcher.Match(input[pos-1 : pos+1])
+	}
+
+	if pos+1 < len(input) {
+		canOpen = openingUnderscoreMatcher.Match(input[pos : pos+2])
+	}
+
+	if canOpen || canClose {
+		ds.push(&delimiter{
+			kind:     delimiterUnderscore,
+			span:     span,
+			canOpen:  canOpen,
+			canClose: canClose,
+		})
+	}
+	return pos + 1
+}
+
+var (
+	openingTildeMatcher = regexp.MustCompile(`~~[^\s]`)
+	closingTildeMatcher = regexp.MustCompile(`[^\s]~~`)
+)
+
+// consumeTilde handles ~~ for strikethrough. Any other number of tildes in a
+// row is just text.
+func consumeTilde(sl *spanList, ds *delimiterStack, input []byte, pos int) int {
+	end := pos
+	for end < len(input) && input[end] == '~' {
+		end++
+	}
+
+	if end-pos != 2 {
+		sl.push(input[pos:end], pos)
+		return end
+	}
+
+	span := sl.push([]byte(`~~`), pos)
+
+	canOpen := false
+	canClose := false
+
+	if pos > 0 {
+		canClose = closingTildeMatcher.Match(input[pos-1 : pos+2])
+	}
+
+	if pos+2 < len(input) {
+		canOpen = openingTildeMatcher.Match(input[pos : pos+3])
+	}
+
+	if canOpen || canClose {
+		ds.push(&delimiter{
+			kind:     delimiterTilde,
+			span:     span,
+			canOpen:  canOpen,
+			canClose: canClose,
+		})
+	}
+
+	return end
+}
+
+func consumeOpenBracket(sl *spanList, ds *delimiterStack, input []byte, pos int) int {
+	span := sl.push([]byte(`[`), pos)
+
+	ds.push(&delimiter{
+		kind:     delimiterOpenBracket,
+		span:     span,
+		canOpen:  true,
+		canClose: false,
+	})
+
+	return pos + 1
+}
+
+var wikiLinkMatcher = regexp.MustCompile(`^\[\[([^\[\]|\n]+)(?:\|([^\[\]\n]+))?\]\]`)
+
+// consumeWikiLink handles [[Title]] and [[Title|label]] links to other
+// articles. They are resolved when rendered, so that links to articles that
+// don't exist yet can be told apart.
+func consumeWikiLink(sl *spanList, src *source, input []byte, pos int, opts ParseOptions, defs linkDefinitions) int {
+	idx := wikiLinkMatcher.FindSubmatchIndex(input[pos:])
+
+	// the label defaults to the title
+	start, end := idx[2], idx[3]
+	if idx[4] != -1 {
+		start, end = idx[4], idx[5]
+	}
+
+	label := input[pos+start : pos+end]
+	start += len(label) - len(bytes.TrimLeft(label, " \t"))
+	label = bytes.TrimSpace(label)
+
+	sl.pushInline(&Node{
+		Kind:     NodeWikiLink,
+		Target:   string(bytes.TrimSpace(input[pos+idx[2] : pos+idx[3]])),
+		Children: withoutLinks(parseSpans(label, []Position{src.position(pos + start)}, opts, defs)),
+	}, pos)
+
+	return pos + idx[1]
+}
+
+func consumeImageBracket(sl *spanList, ds *delimiterStack, input []byte, pos int) int {
+	span := sl.push([]byte(`![`), pos)
+
+	ds.push(&delimiter{
+		kind:     delimiterImageBracket,
+		span:     span,
+		canOpen:  true,
+		canClose: false,
+	})
+
+	return pos + 2
+}
+
+var linkURLMatcher = regexp.MustCompile(`^\]\(([^\s\)]+)(?:\s+"([^"]*)")?\)`)
+
+// consumeCloseBracket finishes a link or image if the closest [ or ![ is
+// followed by a URL in parentheses or refers to a link definition.
+func consumeCloseBracket(sl *spanList, ds *delimiterStack, input []byte, pos int, opts ParseOptions, defs linkDefinitions) int {
+	span := sl.push([]byte(`]`), pos)
+
+	var opener *delimiter
+	for d := ds.end; d != nil; d = d.prev {
+		if d.kind == delimiterOpenBracket || d.kind == delimiterImageBracket {
+			opener = d
+			break
+		}
+	}
+
+	if opener == nil {
+		return pos + 1
+	}
+
+	var dest, title string
+	var end int
+
+	if matches := linkURLMatcher.FindSubmatch(input[pos:]); matches != nil {
+		u, err := url.Parse(string(matches[1]))
+		if err != nil {
+			return pos + 1
+		}
+
+		dest, title, end = u.String(), string(matches[2]), pos+len(matches[0])
+	} else if def, n, ok := referenceLink(input, opener, pos, defs); ok {
+		dest, title, end = def.dest, def.title, n
+	} else {
+		return pos + 1
+	}
+
+	if !allowedURL(dest, opts) {
+		return pos + 1
+	}
+
+	ds.truncateAt(opener)
+	ds.rm(opener)
+
+	kind := NodeLink
+	if opener.kind == delimiterImageBracket {
+		kind = NodeImage
+	}
+
+	opener.span.kind = spanOpen
+	opener.span.node = &Node{
+		Kind:        kind,
+		Destination: dest,
+		Title:       title,
+	}
+
+	span.kind = spanClose
+	return end
+}
+
+// linkDefinitions holds a document's link definitions by their normalized
+// labels.
+type linkDefinitions map[string]*block
+
+// normalizeLabel makes labels that only differ in case or whitespace the
+// same. The strings package only maps case one rune at a time, so ß, which
+// is SS in upper case, has to be folded by hand.
+func normalizeLabel(label []byte) string {
+	folded := strings.ToLower(strings.ToUpper(strings.Join(strings.Fields(string(label)), " ")))
+	return strings.ReplaceAll(folded, "ß", "ss")
+}
+
+var referenceLabelMatcher = regexp.MustCompile(`^\[((?:[^\[\]\\]|\\.){0,999})\]`)
+
+// referenceLink finds the definition a reference link whose text ends with
+// the ] at pos refers to. The label is either in brackets after the text,
+// [text][label], or is the text itself, [text][] or just [text]. It returns
+// where the reference ends.
+func referenceLink(input []byte, opener *delimiter, pos int, defs linkDefinitions) (*block, int, bool) {
+	label := input[opener.span.offset+len(opener.span.text) : pos]
+	end := pos + 1
+
+	if matches := referenceLabelMatcher.FindSubmatch(input[pos+1:]); matches != nil {
+		end += len(matches[0])
+
+		if len(matches[1]) != 0 {
+			label = matches[1]
+		}
+	}
+
+	// labels are at most 999 characters, which also keeps looking them up cheap
+	if len(label) > 999 {
+		return nil, 0, false
+	}
+
+	def, ok := defs[normalizeLabel(label)]
+	if !ok {
+		return nil, 0, false
+	}
+
+	return def, end, true
+}
+
+var urlSchemeMatcher = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9+.-]*):`)
+
+// allowedURL reports whether a link or image may point to dest. The scheme
+// is found the way a browser would find it, ignoring the whitespace and
+// control characters browsers ignore, so that they can't be used to sneak
+// something like javascript: past the check.
+func allowedURL(dest string, opts ParseOptions) bool {
+	dest = strings.TrimFunc(dest, func(r rune) bool { return r <= ' ' })
+	dest = strings.NewReplacer("\t", "", "\n", "", "\r", "").Replace(dest)
+
+	matches := urlSchemeMatcher.FindStringSubmatch(dest)
+	if matches == nil {
+		// relative
+		return true
+	}
+
+	schemes := opts.URLSchemes
+	if schemes == nil {
+		schemes = DefaultURLSchemes
+	}
+
+	for _, scheme := range schemes {
+		if strings.EqualFold(matches[1], scheme) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// inLinkText reports whether there is a bracket that could still become the
+// start of a link's text, links can't contain other links.
+func inLinkText(ds *delimiterStack) bool {
+	return ds.openBrackets > 0
+}
+
+// withoutLinks replaces any links in nodes with their contents.
+func withoutLinks(nodes []*Node) []*Node {
+	out := []*Node{}
+
+	for _, n := range nodes {
+		if n.Kind == NodeLink {
+			out = append(out, withoutLinks(n.Children)...)
+			continue
+		}
+
+		n.Children = withoutLinks(n.Children)
+		out = append(out, n)
+	}
+
+	return out
+}
+
+var (
+	autolinkURIMatcher   = regexp.MustCompile(`^<([A-Za-z][A-Za-z0-9+.-]{1,31}:[^\s<>\x00-\x1f\x7f]*)>`)
+	autolinkEmailMatcher = regexp.MustCompile("^<([a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*)>")
+)
+
+func isAutolink(input []byte) bool {
+	return autolinkURIMatcher.Match(input) || autolinkEmailMatcher.Match(input)
+}
+
+// consumeAutolink handles a URL or email address in angle brackets, which
+// links to itself. If the URL's scheme isn't allowed it is left as text.
+func consumeAutolink(sl *spanList, src *source, input []byte, pos int, opts ParseOptions) int {
+	var text []byte
+	var dest string
+
+	if matches := autolinkURIMatcher.FindSubmatch(input[pos:]); matches != nil {
+		text = matches[1]
+		dest = normalizeURI(text)
+	} else {
+		text = autolinkEmailMatcher.FindSubmatch(input[pos:])[1]
+		dest = "mailto:" + normalizeURI(text)
+	}
+
+	end := pos + len(text) + 2
+
+	if !allowedURL(dest, opts) {
+		sl.push(input[pos:end], pos)
+		return end
+	}
+
+	sl.pushInline(&Node{
+		Kind:        NodeLink,
+		Destination: dest,
+		Children:    []*Node{{Kind: NodeText, Pos: src.position(pos + 1), Literal: text}},
+	}, pos)
+
+	return end
+}
+
+var bareURLMatcher = regexp.MustCompile(`^(?:https?://|www\.)[A-Za-z0-9_-]+(?:\.[A-Za-z0-9_-]+)*(?::[0-9]+)?(?:[/?#][^\s<]*)?`)
+
+// canOpenBareURL reports whether a URL starting with http://, https:// or
+// www. starts at pos. It has to be at the start of a word, though it can be
+// formatted or in parentheses.
+func canOpenBareURL(ds *delimiterStack, input []byte, pos int) bool {
+	if input[pos] != 'h' && input[pos] != 'w' {
+		return false
+	}
+
+	if pos > 0 && !bytes.ContainsAny(input[pos-1:pos], " \t\n*_~(") {
+		return false
+	}
+
+	return bareURLMatcher.Match(input[pos:]) && !inLinkText(ds)
+}
+
+// consumeBareURL turns a URL in the text into a link to itself. Punctuation
+// at the end of the URL is taken to be part of the sentence around it, as
+// is a closing parenthesis without an opening one in the URL.
+func consumeBareURL(sl *spanList, src *source, input []byte, pos int, opts ParseOptions) int {
+	text := bareURLMatcher.Find(input[pos:])
+
+	for {
+		last := text[len(text)-1]
+
+		if bytes.IndexByte([]byte(`?!.,:*_~'"`), last) != -1 {
+			text = text[:len(text)-1]
+			continue
+		}
+
+		if last == ')' && bytes.Count(text, []byte(`(`)) < bytes.Count(text, []byte(`)`)) {
+			text = text[:len(text)-1]
+			continue
+		}
+
+		break
+	}
+
+	dest := normalizeURI(text)
+	if bytes.HasPrefix(text, []byte(`www.`)) {
+		dest = "http://" + dest
+	}
+
+	// there has to be something left after the punctuation is trimmed
+	if !bareURLMatcher.Match(text) || !allowedURL(dest, opts) {
+		sl.push(text, pos)
+		return pos + len(text)
+	}
+
+	sl.pushInline(&Node{
+		Kind:        NodeLink,
+		Destination: dest,
+		Children:    []*Node{{Kind: NodeText, Pos: src.position(pos), Literal: text}},
+	}, pos)
+
+	return pos + len(text)
+}
+
+// consumeBacktick handles code spans. A code span opened by a run of n
+// backticks is closed by the next run of exactly n backticks, and nothing
+// between them is formatted.
+func consumeBacktick(sl *spanList, input []byte, pos int) int {
+	n := backtickRunLength(input, pos)
+
+	for end := pos + n; end < len(input); {
+		if input[end] != '`' {
+			end++
+			continue
+		}
+
+		m := backtickRunLength(input, end)
+		if m != n {
+			end += m
+			continue
+		}
+
+		code := bytes.ReplaceAll(input[pos+n:end], []byte("\n"), []byte(" "))
+		if len(code) >= 2 && code[0] == ' ' && code[len(code)-1] == ' ' && len(bytes.Trim(code, " ")) != 0 {
+			code = code[1 : len(code)-1]
+		}
+
+		sl.pushInline(&Node{Kind: NodeCode, Literal: code}, pos)
+
+		return end + n
+	}
+
+	// no closing run, the backticks are just text
+	sl.push(input[pos:pos+n], pos)
+	return pos + n
+}
+
+func backtickRunLength(input []byte, pos int) int {
+	n := 0
+	for pos+n < len(input) && input[pos+n] == '`' {
+		n++
+	}
+
+	return n
+}
+
+func consumeBackslash(sl *spanList, input []byte, pos int, opts ParseOptions) int {
+	if pos+1 >= len(input) {
+		// we're at the end
+		sl.push([]byte(`\`), pos)
+		return pos + 1
+	}
+
+	// a backslash at the end of a line is a hard line break
+	if input[pos+1] == '\n' {
+		sl.pushInline(&Node{Kind: NodeLineBreak}, pos)
+		return pos + 2
+	}
+
+	// CommonMark lets any ASCII punctuation be escaped
+	if opts.CommonMark && isASCIIPunct(input[pos+1]) {
+		sl.push(input[pos+1:pos+2], pos)
+		return pos + 2
+	}
+
+	next := []byte{input[pos+1]}
+
+	switch {
+	case bytes.Equal(next, []byte(`*`)):
+		sl.push([]byte(`*`), pos)
+		return pos + 2
+	case bytes.Equal(next, []byte(`_`)):
+		sl.push([]byte(`_`), pos)
+		return pos + 2
+	case bytes.Equal(next, []byte(`[`)):
+		sl.push([]byte(`[`), pos)
+		return pos + 2
+	case bytes.Equal(next, []byte(`!`)):
+		sl.push([]byte(`!`), pos)
+		return pos + 2
+	case bytes.Equal(next, []byte(`]`)):
+		sl.push([]byte(`]`), pos)
+		return pos + 2
+	case bytes.Equal(next, []byte(`#`)):
+		sl.push([]byte(`#`), pos)
+		return pos + 2
+	case bytes.Equal(next, []byte(`>`)):
+		sl.push([]byte(`>`), pos)
+		return pos + 2
+	case bytes.Equal(next, []byte(`-`)):
+		sl.push([]byte(`-`), pos)
+		return pos + 2
+	case bytes.Equal(next, []byte(`+`)):
+		sl.push([]byte(`+`), pos)
+		return pos + 2
+	case bytes.Equal(next, []byte(`.`)):
+		sl.push([]byte(`.`), pos)
+		return pos + 2
+	case bytes.Equal(next, []byte(`)`)):
+		sl.push([]byte(`)`), pos)
+		return pos + 2
+	case bytes.Equal(next, []byte("`")):
+		sl.push([]byte("`"), pos)
+		return pos + 2
+	case bytes.Equal(next, []byte(`|`)):
+		sl.push([]byte(`|`), pos)
+		return pos + 2
+	case bytes.Equal(next, []byte(`~`)):
+		sl.push([]byte(`~`), pos)
+		return pos + 2
+	case bytes.Equal(next, []byte(`<`)):
+		sl.push([]byte(`<`), pos)
+		return pos + 2
+	case bytes.Equal(next, []byte(`\`)):
+		sl.push([]byte(`\`), pos)
+		return pos + 2
+	default: // nothing to escape
+		sl.push([]byte(`\`), pos)
+		return pos + 1
+	}
+}
+
+func processEmphasis(ds *delimiterStack) {
+	for closer := nextClosingDelimiter(ds); closer != nil; closer = nextClosingDelimiter(ds) {
+		opener := openingDelimiter(ds, closer)
+		if opener == nil {
+			// it may still open, like the _ in (_this_), but not in the
+			// middle of a word, like the first _ in snake_case_name
+			if closer.canOpen && !afterWord(closer.span) {
+				closer.canClose = false
+			} else {
+				ds.rm(closer)
+			}
+
+			continue
+		}
+
+		opener.span.kind = spanOpen
+		closer.span.kind = spanClose
+
+		if opener.kind == delimiterUnderscore {
+			opener.span.node = &Node{Kind: NodeEmph}
+		}
+
+		if opener.kind == delimiterAsterisk {
+			opener.span.node = &Node{Kind: NodeStrong}
+		}
+
+		if opener.kind == delimiterTilde {
+			opener.span.node = &Node{Kind: NodeStrikethrough}
+		}
+
+		rmBetween(ds, opener, closer)
+	}
+}
+
+// afterWord reports whether sp comes right after a letter or digit.
+func afterWord(sp *span) bool {
+	if sp.prev == nil || sp.prev.kind != spanText || len(sp.prev.text) == 0 {
+		return false
+	}
+
+	r, _ := utf8.DecodeLastRune(sp.prev.text)
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func openingDelimiter(ds *delimiterStack, closer *delimiter) *delimiter {
+	for d := closer.prev; d != nil; d = d.prev {
+		if d.canOpen && d.kind == closer.kind {
+			return d
+		}
+	}
+
+	return nil
+}
+
+func nextClosingDelimiter(ds *delimiterStack) *delimiter {
+	for d := ds.begin; d != nil; d = d.next {
+		if d.canClose {
+			return d
+		}
+	}
+
+	return nil
+}
+
+func rmBetween(ds *delimiterStack, begin *delimiter, end *delimiter) {
+	ptr := begin
+	for ptr != end.next {
+		next := ptr.next
+		ds.rm(ptr)
+		ptr = next
+	}
+}
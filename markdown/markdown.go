@@ -0,0 +1,92 @@
+// Package markdown parses the wiki's dialect of markdown into a document
+// tree and renders documents as HTML.
+package markdown
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+)
+
+// LinkResolver decides where a [[Title]] link points and whether the article
+// it points to exists.
+type LinkResolver interface {
+	ResolveLink(title string) (href string, exists bool)
+}
+
+// articleLinks is used when no resolver is given. It assumes every article
+// exists.
+type articleLinks struct{}
+
+func (articleLinks) ResolveLink(title string) (string, bool) {
+	return "/articles/" + url.PathEscape(title), true
+}
+
+// GenerateHTML renders markdown as HTML, assuming every article linked to
+// exists.
+func GenerateHTML(input []byte) ([]byte, error) {
+	return GenerateHTMLWithLinks(input, articleLinks{})
+}
+
+// GenerateHTMLWithLinks renders markdown as HTML, resolving [[Title]] links
+// with links.
+func GenerateHTMLWithLinks(input []byte, links LinkResolver) ([]byte, error) {
+	doc, err := Parse(input)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+
+	err = HTMLRenderer{Links: links}.Render(&buf, doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// ToggleTask checks or unchecks the task list item whose checkbox is on line
+// of input, returning a copy of input with only that checkbox changed.
+func ToggleTask(input []byte, line int) ([]byte, error) {
+	doc, err := Parse(input)
+	if err != nil {
+		return nil, err
+	}
+
+	var task *Node
+
+	Walk(doc, func(n *Node) bool {
+		if n.Kind == NodeListItem && n.Task && n.Children[0].Pos.Line == line {
+			task = n
+		}
+
+		return task == nil
+	})
+
+	if task == nil {
+		return nil, fmt.Errorf("there is no task on line %d", line)
+	}
+
+	// columns count bytes, and the lines are the same with or without \r
+	lines := bytes.SplitAfter(input, []byte("\n"))
+	offset := len(bytes.Join(lines[:line-1], nil)) + task.Children[0].Pos.Column - 1
+
+	if offset+2 >= len(input) || input[offset] != '[' || input[offset+2] != ']' {
+		return nil, fmt.Errorf("could not find the checkbox on line %d", line)
+	}
+
+	out := append([]byte{}, input...)
+
+	if task.Checked {
+		out[offset+1] = ' '
+	} else {
+		out[offset+1] = 'x'
+	}
+
+	return out, nil
+}
+
+func sanitizeNewlines(b []byte) []byte {
+	return bytes.ReplaceAll(b, []byte("\r\n"), []byte("\n"))
+}
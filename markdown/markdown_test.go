@@ -0,0 +1,508 @@
+package markdown
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustReadFixture(filename string) []byte {
+	buf, err := os.ReadFile(filepath.Join("testdata", filename))
+	if err != nil {
+		panic(err)
+	}
+
+	return buf
+}
+
+func lineDiff(expected, actual []byte) string {
+	s := new(strings.Builder)
+
+	expectedL := bytes.SplitAfter(expected, []byte("\n"))
+	actualL := bytes.SplitAfter(actual, []byte("\n"))
+
+	max := len(expectedL)
+	if max < len(actualL) {
+		max = len(actualL)
+	}
+
+	for i := 0; i < max; i++ {
+		if i >= len(expectedL) {
+			fmt.Fprintln(s, "line: ", i)
+			fmt.Fprintln(s, "not present in expected")
+			fmt.Fprintf(s, "actual: %#v\n", string(actualL[i]))
+		} else if i >= len(actualL) {
+			fmt.Fprintln(s, "line: ", i)
+			fmt.Fprintf(s, "expected : %#v\n", string(expectedL[i]))
+			fmt.Fprintln(s, "not present in B")
+		} else if !bytes.Equal(expectedL[i], actualL[i]) {
+			fmt.Fprintln(s, "line: ", i)
+			fmt.Fprintf(s, "expected : %#v\n", string(expectedL[i]))
+			fmt.Fprintf(s, "actual   : %#v\n", string(actualL[i]))
+		}
+	}
+
+	return s.String()
+}
+
+func TestGenerateHTML(t *testing.T) {
+	tt := []string{
+		"comprehensive",
+		"h1",
+		"h2",
+		"h3",
+		"h4",
+		"h5",
+		"h6",
+		"header_leading_space",
+		"header_no_space",
+		"header_escaped",
+		"header_no_lazy",
+		"header_empty",
+		"heading_ids",
+		"toc",
+		"toc_not_toc",
+		"blockquote",
+		"blockquote_lazy",
+		"blockquote_bad",
+		"blockquote_blocks",
+		"blockquote_nested",
+		"blockquote_lazy_blocks",
+		"code_block",
+		"code_block_no_close",
+		"code_block_info",
+		"code_block_tilde",
+		"code_block_long_fence",
+		"highlight_go",
+		"highlight_shell",
+		"highlight_json",
+		"highlight_sql",
+		"highlight_yaml",
+		"paragraph",
+		"hard_line_break",
+		"extra_blank_lines",
+		"inline_em",
+		"inline_strong",
+		"inline_em_strong_nested",
+		"inline_em_no_match",
+		"inline_em_precedence",
+		"inline_em_punctuation",
+		"inline_strikethrough",
+		"inline_link",
+		"inline_link_bad_url",
+		"inline_link_xss",
+		"inline_link_inside_em",
+		"inline_link_breaks_em",
+		"inline_autolink",
+		"inline_bare_url",
+		"reference_link",
+		"inline_image",
+		"inline_image_alt",
+		"inline_image_bad",
+		"inline_image_xss",
+		"wiki_link",
+		"wiki_link_not_link",
+		"inline_code",
+		"inline_code_multi_backtick",
+		"inline_code_no_match",
+		"list_unordered",
+		"list_markers",
+		"list_ordered",
+		"list_nested",
+		"list_loose",
+		"list_lazy",
+		"list_not_list",
+		"list_blocks",
+		"list_empty_item",
+		"list_task",
+		"table",
+		"table_align",
+		"table_cells",
+		"table_not_table",
+	}
+
+	for _, tc := range tt {
+		t.Run(tc, func(t *testing.T) {
+			out, err := GenerateHTML(mustReadFixture(tc + ".md"))
+			if err != nil {
+				t.Fatalf("test case: '%s'\nunexpected error: %s", tc, err.Error())
+			}
+
+			expected := mustReadFixture(tc + ".out")
+
+			if !bytes.Equal(out, expected) {
+				t.Fatalf("test case: '%s'\ndiff:\n%s\n", tc, lineDiff(expected, out))
+			}
+		})
+	}
+}
+
+type fixtureLinks map[string]bool
+
+func (f fixtureLinks) ResolveLink(title string) (string, bool) {
+	return "/wiki/" + title, f[title]
+}
+
+func TestGenerateHTMLWithLinks(t *testing.T) {
+	links := fixtureLinks{"Exists": true}
+
+	out, err := GenerateHTMLWithLinks(mustReadFixture("wiki_link_missing.md"), links)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	expected := mustReadFixture("wiki_link_missing.out")
+
+	if !bytes.Equal(out, expected) {
+		t.Fatalf("diff:\n%s\n", lineDiff(expected, out))
+	}
+}
+
+func TestParseWithOptions(t *testing.T) {
+	tt := []struct {
+		name string
+		opts ParseOptions
+	}{
+		{"commonmark_xss", ParseOptions{CommonMark: true}},
+		{"url_schemes", ParseOptions{URLSchemes: []string{"ftp"}}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			doc, err := ParseWithOptions(mustReadFixture(tc.name+".md"), tc.opts)
+			if err != nil {
+				t.Fatalf("test case: '%s'\nunexpected error: %s", tc.name, err.Error())
+			}
+
+			var out bytes.Buffer
+
+			err = HTMLRenderer{}.Render(&out, doc)
+			if err != nil {
+				t.Fatalf("test case: '%s'\nunexpected error: %s", tc.name, err.Error())
+			}
+
+			expected := mustReadFixture(tc.name + ".out")
+
+			if !bytes.Equal(out.Bytes(), expected) {
+				t.Fatalf("test case: '%s'\ndiff:\n%s\n", tc.name, lineDiff(expected, out.Bytes()))
+			}
+		})
+	}
+}
+
+func TestRenderToggleTasks(t *testing.T) {
+	doc, err := Parse(mustReadFixture("list_task.md"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	var out bytes.Buffer
+
+	err = HTMLRenderer{ToggleTasks: true}.Render(&out, doc)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	expected := mustReadFixture("list_task_toggle.out")
+
+	if !bytes.Equal(out.Bytes(), expected) {
+		t.Fatalf("diff:\n%s\n", lineDiff(expected, out.Bytes()))
+	}
+}
+
+func TestToggleTask(t *testing.T) {
+	tt := []struct {
+		line     int
+		expected string
+	}{
+		{1, "- [x] to do\n"},
+		{3, "- [ ] also done\n"},
+		{4, "-  [x] _formatted_ [link](/to)\n"},
+		{11, "- [ ] with\n"},
+		{28, "    - [ ] work too\n"},
+	}
+
+	for _, newline := range []string{"\n", "\r\n"} {
+		input := bytes.ReplaceAll(mustReadFixture("list_task.md"), []byte("\n"), []byte(newline))
+
+		for _, tc := range tt {
+			out, err := ToggleTask(input, tc.line)
+			if err != nil {
+				t.Fatalf("line %d: unexpected error: %s", tc.line, err.Error())
+			}
+
+			outL := bytes.SplitAfter(out, []byte(newline))
+			inputL := bytes.SplitAfter(input, []byte(newline))
+
+			for i := range inputL {
+				expected := string(inputL[i])
+				if i == tc.line-1 {
+					expected = strings.ReplaceAll(tc.expected, "\n", newline)
+				}
+
+				if string(outL[i]) != expected {
+					t.Fatalf("line %d: expected line %d to be %q, got %q", tc.line, i+1, expected, outL[i])
+				}
+			}
+		}
+	}
+
+	for _, line := range []int{5, 14, 18, 22, 26, 100} {
+		_, err := ToggleTask(mustReadFixture("list_task.md"), line)
+		if err == nil {
+			t.Fatalf("line %d: expected an error", line)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tt := []struct {
+		name     string
+		input    string
+		opts     ParseOptions
+		expected Position
+	}{
+		{"invalid UTF-8", "a\nb\xffc\n", ParseOptions{Strict: true}, Position{Line: 2, Column: 2}},
+		{"invalid UTF-8 after multibyte", "ok\n\n- \u00e9 \xfe\n", ParseOptions{Strict: true}, Position{Line: 3, Column: 6}},
+		{"invalid UTF-8 after CRLF", "a\r\n\xc3(", ParseOptions{Strict: true}, Position{Line: 2, Column: 1}},
+		{"quotes too deep", "text\n\n" + strings.Repeat("> ", 33) + "a\n", ParseOptions{Strict: true}, Position{Line: 3, Column: 65}},
+		{"lists too deep", nestedLists(33), ParseOptions{Strict: true}, Position{Line: 33, Column: 65}},
+		{"quotes and lists too deep", strings.Repeat("> - ", 17) + "a\n", ParseOptions{Strict: true}, Position{Line: 1, Column: 65}},
+		{"CommonMark quotes too deep", strings.Repeat(">", 33) + "a\n", ParseOptions{CommonMark: true, Strict: true}, Position{Line: 1, Column: 33}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseWithOptions([]byte(tc.input), tc.opts)
+
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("expected a *ParseError, got %v", err)
+			}
+
+			if parseErr.Pos != tc.expected {
+				t.Fatalf("expected error at %s, got %s: %s", tc.expected, parseErr.Pos, parseErr.Msg)
+			}
+		})
+	}
+}
+
+func TestParseNestingLimit(t *testing.T) {
+	tt := []string{
+		strings.Repeat("> ", 32) + "a\n",
+		nestedLists(32),
+	}
+
+	for _, input := range tt {
+		_, err := ParseWithOptions([]byte(input), ParseOptions{Strict: true})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+}
+
+func TestParseLenient(t *testing.T) {
+	tt := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"invalid UTF-8", "a\nb\xffc\n", "<p>a\nb\uFFFDc</p>\n"},
+		{"invalid UTF-8 after CRLF", "a\r\n\xc3(", "<p>a\n\uFFFD(</p>\n"},
+		{"quotes too deep", strings.Repeat("> ", 34) + "a\n", strings.Repeat("<blockquote>\n", 33) + "<p>&gt; a</p>\n" + strings.Repeat("</blockquote>\n", 33)},
+		{"empty quote too deep", strings.Repeat("> ", 32) + ">\n", strings.Repeat("<blockquote>\n", 33) + strings.Repeat("</blockquote>\n", 33)},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := GenerateHTML([]byte(tc.input))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			if string(actual) != tc.expected {
+				t.Fatalf("expected %q, got %q", tc.expected, actual)
+			}
+		})
+	}
+
+	// lists past the limit are left as text too
+	actual, err := GenerateHTML([]byte(nestedLists(40)))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	if lists := strings.Count(string(actual), "<ul>"); lists != 33 {
+		t.Fatalf("expected 33 lists, got %d", lists)
+	}
+
+	if !strings.Contains(string(actual), "- item") {
+		t.Fatalf("expected the deepest lists as text, got %q", actual)
+	}
+}
+
+// nestedLists is n lists, each nested in the one before it.
+func nestedLists(n int) string {
+	s := new(strings.Builder)
+
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(s, "%s- item\n", strings.Repeat("  ", i))
+	}
+
+	return s.String()
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestRenderErrors(t *testing.T) {
+	text := &Node{Kind: NodeText, Pos: Position{Line: 2, Column: 3}, Literal: []byte("a")}
+
+	tt := []struct {
+		name     string
+		doc      *Node
+		w        io.Writer
+		expected string
+	}{
+		{
+			name:     "no document",
+			doc:      nil,
+			w:        io.Discard,
+			expected: "there is no document to render",
+		},
+		{
+			name:     "unknown kind",
+			doc:      &Node{Kind: NodeDocument, Children: []*Node{{Kind: NodeKind(-1), Pos: Position{Line: 1, Column: 1}}}},
+			w:        io.Discard,
+			expected: "1:1: unrecognized node kind: -1",
+		},
+		{
+			name:     "table row outside a table",
+			doc:      &Node{Kind: NodeDocument, Children: []*Node{{Kind: NodeTableRow, Pos: Position{Line: 4, Column: 1}}}},
+			w:        io.Discard,
+			expected: "4:1: TABLE_ROW can't be inside DOCUMENT",
+		},
+		{
+			name:     "text directly in a table",
+			doc:      &Node{Kind: NodeDocument, Children: []*Node{{Kind: NodeTable, Children: []*Node{text}}}},
+			w:        io.Discard,
+			expected: "2:3: TEXT can't be inside TABLE",
+		},
+		{
+			name:     "nil child",
+			doc:      &Node{Kind: NodeDocument, Children: []*Node{{Kind: NodeParagraph, Pos: Position{Line: 5, Column: 1}, Children: []*Node{nil}}}},
+			w:        io.Discard,
+			expected: "5:1: PARAGRAPH has a nil child",
+		},
+		{
+			name:     "failed write",
+			doc:      &Node{Kind: NodeDocument, Children: []*Node{{Kind: NodeParagraph, Children: []*Node{text}}}},
+			w:        failingWriter{},
+			expected: "write failed",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := HTMLRenderer{}.Render(tc.w, tc.doc)
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+
+			if err.Error() != tc.expected {
+				t.Fatalf("expected error %q, got %q", tc.expected, err.Error())
+			}
+		})
+	}
+}
+
+// parseTime is the fastest of a few parses of input, to keep timings steady.
+func parseTime(t *testing.T, input []byte) time.Duration {
+	fastest := time.Duration(0)
+
+	for i := 0; i < 3; i++ {
+		start := time.Now()
+
+		_, err := Parse(input)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+
+		if d := time.Since(start); i == 0 || d < fastest {
+			fastest = d
+		}
+	}
+
+	return fastest
+}
+
+func TestParseLazyLinesLinearTime(t *testing.T) {
+	tt := []string{
+		"> a\n",
+		"> - a\n",
+		"- > a\n",
+		"> > - > a\n",
+	}
+
+	for _, start := range tt {
+		t.Run(start, func(t *testing.T) {
+			short := parseTime(t, []byte(start+strings.Repeat("lazy line\n", 2500)))
+			long := parseTime(t, []byte(start+strings.Repeat("lazy line\n", 10000)))
+
+			// four times the lines should take about four times as long, and
+			// would take sixteen times as long if every line parsed the ones
+			// before it again
+			if long > 10*short {
+				t.Fatalf("10000 lazy lines took %s, 2500 took %s", long, short)
+			}
+		})
+	}
+}
+
+func writeTree(w io.Writer, n *Node, depth int) {
+	fmt.Fprintf(w, "%s%s %s", strings.Repeat("  ", depth), n.Kind, n.Pos)
+	if n.Literal != nil {
+		fmt.Fprintf(w, " %q", n.Literal)
+	}
+	fmt.Fprintln(w)
+
+	for _, c := range n.Children {
+		writeTree(w, c, depth+1)
+	}
+}
+
+func TestParse(t *testing.T) {
+	doc, err := Parse(mustReadFixture("parse_tree.md"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	var out bytes.Buffer
+	writeTree(&out, doc, 0)
+
+	expected := mustReadFixture("parse_tree.tree")
+
+	if !bytes.Equal(out.Bytes(), expected) {
+		t.Fatalf("diff:\n%s\n", lineDiff(expected, out.Bytes()))
+	}
+}
+
+func TestParseBareURLsLinearTime(t *testing.T) {
+	// the bracket is never closed, so every URL is in the text of a link that
+	// might still be made, with more and more delimiters after the bracket
+	short := parseTime(t, []byte("[ "+strings.Repeat("*a www.example.com ", 2500)))
+	long := parseTime(t, []byte("[ "+strings.Repeat("*a www.example.com ", 10000)))
+
+	if long > 10*short {
+		t.Fatalf("10000 URLs took %s, 2500 took %s", long, short)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+)
+
+const (
+	conflictCurrentMarker = "<<<<<<< current\n"
+	conflictDivider       = "=======\n"
+	conflictYoursMarker   = ">>>>>>> yours\n"
+)
+
+// merge3 performs a line based three-way merge of two texts that were both
+// derived from base. Lines changed on only one side are taken from that
+// side. Where both sides changed the same lines differently the merge is not
+// clean and both versions are included between conflict markers. If either
+// side is too different from base to diff the error is errTooDifferent.
+func merge3(base, current, yours []byte) ([]byte, bool, error) {
+	o := splitLines(base)
+	a := splitLines(current)
+	b := splitLines(yours)
+
+	ma, err := matchLines(o, a)
+	if err != nil {
+		return nil, false, err
+	}
+
+	mb, err := matchLines(o, b)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var buf bytes.Buffer
+	clean := true
+
+	i, j, k := 0, 0, 0
+	for i < len(o) || j < len(a) || k < len(b) {
+		// lines unchanged on both sides
+		n := 0
+		for i+n < len(o) && ma[i+n] == j+n && mb[i+n] == k+n {
+			n++
+		}
+
+		if n > 0 {
+			writeLines(&buf, o[i:i+n])
+			i, j, k = i+n, j+n, k+n
+			continue
+		}
+
+		// find the end of the chunk that changed on at least one side
+		next := i
+		for next < len(o) && (ma[next] == -1 || mb[next] == -1) {
+			next++
+		}
+
+		nextJ, nextK := len(a), len(b)
+		if next < len(o) {
+			nextJ, nextK = ma[next], mb[next]
+		}
+
+		if !mergeChunk(&buf, o[i:next], a[j:nextJ], b[k:nextK]) {
+			clean = false
+		}
+
+		i, j, k = next, nextJ, nextK
+	}
+
+	return buf.Bytes(), clean, nil
+}
+
+func mergeChunk(buf *bytes.Buffer, o, a, b []string) bool {
+	switch {
+	case linesEqual(a, o):
+		writeLines(buf, b)
+	case linesEqual(b, o):
+		writeLines(buf, a)
+	case linesEqual(a, b):
+		writeLines(buf, a)
+	default:
+		buf.WriteString(conflictCurrentMarker)
+		writeTerminatedLines(buf, a)
+		buf.WriteString(conflictDivider)
+		writeTerminatedLines(buf, b)
+		buf.WriteString(conflictYoursMarker)
+
+		return false
+	}
+
+	return true
+}
+
+// matchLines maps each line of base to the line of other it is kept as, or
+// -1 if it was removed.
+func matchLines(base, other []string) ([]int, error) {
+	edits, err := diffStrings(base, other)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]int, len(base))
+
+	i, j := 0, 0
+	for _, e := range edits {
+		switch e.op {
+		case diffEqual:
+			matches[i] = j
+			i++
+			j++
+		case diffDelete:
+			matches[i] = -1
+			i++
+		case diffInsert:
+			j++
+		}
+	}
+
+	return matches, nil
+}
+
+func linesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func writeLines(buf *bytes.Buffer, lines []string) {
+	for _, l := range lines {
+		buf.WriteString(l)
+	}
+}
+
+// writeTerminatedLines makes sure conflict markers always start on their
+// own line, even if the last line of a text had no newline.
+func writeTerminatedLines(buf *bytes.Buffer, lines []string) {
+	for _, l := range lines {
+		buf.WriteString(l)
+		if !strings.HasSuffix(l, "\n") {
+			buf.WriteString("\n")
+		}
+	}
+}
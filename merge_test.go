@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestMerge3(t *testing.T) {
+	tt := []struct {
+		name     string
+		base     string
+		current  string
+		yours    string
+		expected string
+		clean    bool
+	}{
+		{
+			name:     "no changes",
+			base:     "a\nb\nc\n",
+			current:  "a\nb\nc\n",
+			yours:    "a\nb\nc\n",
+			expected: "a\nb\nc\n",
+			clean:    true,
+		},
+		{
+			name:     "only current changed",
+			base:     "a\nb\nc\n",
+			current:  "a\nB\nc\n",
+			yours:    "a\nb\nc\n",
+			expected: "a\nB\nc\n",
+			clean:    true,
+		},
+		{
+			name:     "only yours changed",
+			base:     "a\nb\nc\n",
+			current:  "a\nb\nc\n",
+			yours:    "a\nb\nC\n",
+			expected: "a\nb\nC\n",
+			clean:    true,
+		},
+		{
+			name:     "edits that don't overlap",
+			base:     "a\nb\nc\nd\ne\n",
+			current:  "A\nb\nc\nd\ne\n",
+			yours:    "a\nb\nc\nd\nE\n",
+			expected: "A\nb\nc\nd\nE\n",
+			clean:    true,
+		},
+		{
+			name:     "insert and delete that don't overlap",
+			base:     "a\nb\nc\nd\n",
+			current:  "a\nnew\nb\nc\nd\n",
+			yours:    "a\nb\nc\n",
+			expected: "a\nnew\nb\nc\n",
+			clean:    true,
+		},
+		{
+			name:     "the same edit on both sides",
+			base:     "a\nb\nc\n",
+			current:  "a\nB\nc\n",
+			yours:    "a\nB\nc\n",
+			expected: "a\nB\nc\n",
+			clean:    true,
+		},
+		{
+			name:     "edits that overlap",
+			base:     "a\nb\nc\n",
+			current:  "a\nmine\nc\n",
+			yours:    "a\ntheirs\nc\n",
+			expected: "a\n<<<<<<< current\nmine\n=======\ntheirs\n>>>>>>> yours\nc\n",
+			clean:    false,
+		},
+		{
+			name:     "edit and delete of the same line",
+			base:     "a\nb\nc\n",
+			current:  "a\nc\n",
+			yours:    "a\nB\nc\n",
+			expected: "a\n<<<<<<< current\n=======\nB\n>>>>>>> yours\nc\n",
+			clean:    false,
+		},
+		{
+			name:     "empty base with the same text",
+			base:     "",
+			current:  "a\nb\n",
+			yours:    "a\nb\n",
+			expected: "a\nb\n",
+			clean:    true,
+		},
+		{
+			name:     "empty base with different texts",
+			base:     "",
+			current:  "a\nb\n",
+			yours:    "a\nc\n",
+			expected: "<<<<<<< current\na\nb\n=======\na\nc\n>>>>>>> yours\n",
+			clean:    false,
+		},
+		{
+			name:     "current emptied",
+			base:     "a\nb\n",
+			current:  "",
+			yours:    "a\nb\n",
+			expected: "",
+			clean:    true,
+		},
+		{
+			name:     "yours emptied while current changed",
+			base:     "a\nb\n",
+			current:  "a\nB\n",
+			yours:    "",
+			expected: "<<<<<<< current\na\nB\n=======\n>>>>>>> yours\n",
+			clean:    false,
+		},
+		{
+			name:     "no final newline",
+			base:     "a\nb\nc",
+			current:  "A\nb\nc",
+			yours:    "a\nb\nC",
+			expected: "A\nb\nC",
+			clean:    true,
+		},
+		{
+			name:     "final newline added on one side",
+			base:     "a\nb\nc",
+			current:  "a\nb\nc\n",
+			yours:    "A\nb\nc",
+			expected: "A\nb\nc\n",
+			clean:    true,
+		},
+		{
+			name:     "conflict on a last line with no final newline",
+			base:     "a\nb",
+			current:  "a\nmine",
+			yours:    "a\ntheirs",
+			expected: "a\n<<<<<<< current\nmine\n=======\ntheirs\n>>>>>>> yours\n",
+			clean:    false,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			merged, clean, err := merge3([]byte(tc.base), []byte(tc.current), []byte(tc.yours))
+			if err != nil {
+				t.Fatalf("could not merge: %s", err)
+			}
+
+			if string(merged) != tc.expected {
+				t.Fatalf("expected merge %q, got %q", tc.expected, merged)
+			}
+
+			if clean != tc.clean {
+				t.Fatalf("expected clean to be %t, got %t", tc.clean, clean)
+			}
+		})
+	}
+}
+
+func TestMerge3TooDifferent(t *testing.T) {
+	base, yours := &strings.Builder{}, &strings.Builder{}
+	for i := 0; i < maxDiffEdits; i++ {
+		fmt.Fprintf(base, "base %d\n", i)
+		fmt.Fprintf(yours, "yours %d\n", i)
+	}
+
+	_, _, err := merge3([]byte(base.String()), []byte(base.String()+"current\n"), []byte(yours.String()))
+	if !errors.Is(err, errTooDifferent) {
+		t.Fatalf("expected errTooDifferent, got %v", err)
+	}
+}
+
+func TestPostArticleTooDifferentToMerge(t *testing.T) {
+	h, s := newTestArticleHandler(t)
+
+	base, yours := &strings.Builder{}, &strings.Builder{}
+	for i := 0; i < maxDiffEdits; i++ {
+		fmt.Fprintf(base, "base %d\n", i)
+		fmt.Fprintf(yours, "yours %d\n", i)
+	}
+
+	w := postForm(h, "/articles/Different", url.Values{"content": {base.String()}})
+	if w.Code != http.StatusFound {
+		t.Fatalf("expected status %d, got %d", http.StatusFound, w.Code)
+	}
+
+	baseID, err := s.CurrentArticleVersion("Different")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	w = postForm(h, "/articles/Different", url.Values{
+		"content":         {base.String() + "current\n"},
+		"base_version_id": {baseID},
+	})
+	if w.Code != http.StatusFound {
+		t.Fatalf("expected status %d, got %d", http.StatusFound, w.Code)
+	}
+
+	w = postForm(h, "/articles/Different", url.Values{
+		"content":         {yours.String()},
+		"base_version_id": {baseID},
+	})
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected status %d, got %d", http.StatusConflict, w.Code)
+	}
+
+	if !strings.Contains(w.Body.String(), "too different to combine") {
+		t.Fatalf("expected the content not to be merged, got:\n%s", w.Body.String())
+	}
+
+	if !strings.Contains(w.Body.String(), "yours 999") {
+		t.Fatalf("expected the edit form to keep the submitted content")
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+)
+
+type moveArticleView struct {
+	Title string
+}
+
+func moveArticle(w http.ResponseWriter, r *http.Request, s storage, tmpl *template.Template, title string) {
+	to := r.Form.Get("to")
+	if !titleMatcher.MatchString(to) {
+		renderError(w, tmpl, fmt.Errorf("invalid article title: %q", to))
+		return
+	}
+
+	var redirect []byte
+	if r.Form.Get("redirect") == "true" {
+		redirect = redirectContent(to)
+	}
+
+	meta := versionMeta{
+		Author:  requestAuthor(r),
+		Summary: fmt.Sprintf("Moved to %s", to),
+	}
+
+	err := s.MoveArticle(title, to, redirect, meta)
+	if err != nil {
+		renderError(w, tmpl, fmt.Errorf("could not move article: %w", err))
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/articles/%s", to), http.StatusFound)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+var (
+	errRedirectLoop    = errors.New("error: redirect loop")
+	errRedirectTooLong = errors.New("error: too many redirects")
+)
+
+const maxRedirectsToFollow = 10
+
+var redirectMatcher = regexp.MustCompile(`^(?i)\s*#REDIRECT\s*\[\[([0-9a-zA-Z_]+)\]\]`)
+
+// redirectTarget returns the title an article redirects to, if it is a
+// redirect.
+func redirectTarget(content []byte) (string, bool) {
+	matches := redirectMatcher.FindSubmatch(content)
+	if matches == nil {
+		return "", false
+	}
+
+	return string(matches[1]), true
+}
+
+func redirectContent(target string) []byte {
+	return []byte(fmt.Sprintf("#REDIRECT [[%s]]\n", target))
+}
+
+// resolveRedirects follows a chain of redirects from title, which redirects
+// to target, and returns the title the chain ends at. The end of a chain need
+// not exist.
+func resolveRedirects(s storage, title, target string) (string, error) {
+	seen := map[string]bool{title: true}
+
+	for i := 0; ; i++ {
+		if seen[target] {
+			return "", errRedirectLoop
+		}
+
+		if i >= maxRedirectsToFollow {
+			return "", errRedirectTooLong
+		}
+
+		seen[target] = true
+
+		content, err := s.ReadArticle(target)
+		if errors.Is(err, errArticleDNE) {
+			return target, nil
+		} else if err != nil {
+			return "", fmt.Errorf("could not read article: %w", err)
+		}
+
+		next, ok := redirectTarget(content)
+		if !ok {
+			return target, nil
+		}
+
+		target = next
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// retentionPolicy decides which versions of an article survive pruning. A
+// version is kept if any rule keeps it. The zero value keeps everything.
+type retentionPolicy struct {
+	// KeepLast is the number of most recent versions to keep.
+	KeepLast int
+	// KeepWithin keeps every version newer than this.
+	KeepWithin time.Duration
+	// ThinTo keeps the newest version in each interval of this length
+	// (e.g. one per day) for history not kept by the other rules.
+	ThinTo time.Duration
+}
+
+const (
+	day  = 24 * time.Hour
+	week = 7 * day
+)
+
+func newRetentionPolicy(last, within, thin string) (retentionPolicy, error) {
+	p := retentionPolicy{}
+
+	if last != "" {
+		n, err := strconv.Atoi(last)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("invalid number of versions to keep: %q", last)
+		}
+
+		p.KeepLast = n
+	}
+
+	if within != "" {
+		d, err := time.ParseDuration(within)
+		if err != nil || d < 0 {
+			return p, fmt.Errorf("invalid retention duration: %q", within)
+		}
+
+		p.KeepWithin = d
+	}
+
+	switch thin {
+	case "":
+	case "day":
+		p.ThinTo = day
+	case "week":
+		p.ThinTo = week
+	default:
+		return p, fmt.Errorf("invalid thinning interval (must be 'day' or 'week'): %q", thin)
+	}
+
+	return p, nil
+}
+
+func (p retentionPolicy) enabled() bool {
+	return p.KeepLast > 0 || p.KeepWithin > 0 || p.ThinTo > 0
+}
+
+// prunable returns the versions that are not kept by the policy. versions
+// must be sorted newest first. The current version is never prunable.
+func (p retentionPolicy) prunable(versions []string, current string, now time.Time) []string {
+	if !p.enabled() {
+		return nil
+	}
+
+	prune := []string{}
+	seenBuckets := map[time.Time]bool{}
+
+	for i, v := range versions {
+		t, err := versionTime(v)
+		if err != nil {
+			// not something we wrote, leave it alone
+			continue
+		}
+
+		keep := v == current ||
+			i < p.KeepLast ||
+			now.Sub(t) < p.KeepWithin
+
+		// versions kept by the other rules don't use up their interval
+		if !keep && p.ThinTo > 0 {
+			bucket := t.Truncate(p.ThinTo)
+			if !seenBuckets[bucket] {
+				seenBuckets[bucket] = true
+				keep = true
+			}
+		}
+
+		if !keep {
+			prune = append(prune, v)
+		}
+	}
+
+	return prune
+}
+
+func versionTime(version string) (time.Time, error) {
+	nanos, err := strconv.ParseInt(version, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid version ID: %q", version)
+	}
+
+	return time.Unix(0, nanos), nil
+}
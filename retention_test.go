@@ -0,0 +1,94 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPrunable(t *testing.T) {
+	now := time.Date(2021, 6, 30, 12, 0, 0, 0, time.UTC)
+
+	// versions are named by the hours before now they were written
+	version := func(hoursAgo int) string {
+		return strconv.FormatInt(now.Add(-time.Duration(hoursAgo)*time.Hour).UnixNano(), 10)
+	}
+
+	versions := func(hoursAgo ...int) []string {
+		vs := []string{}
+		for _, h := range hoursAgo {
+			vs = append(vs, version(h))
+		}
+
+		return vs
+	}
+
+	tt := []struct {
+		name     string
+		policy   retentionPolicy
+		versions []string
+		current  string
+		expected []string
+	}{
+		{
+			name:     "disabled",
+			policy:   retentionPolicy{},
+			versions: versions(0, 1, 2),
+			current:  version(0),
+			expected: nil,
+		},
+		{
+			name:     "keep last",
+			policy:   retentionPolicy{KeepLast: 2},
+			versions: versions(0, 1, 2, 3),
+			current:  version(0),
+			expected: versions(2, 3),
+		},
+		{
+			name:     "keep within",
+			policy:   retentionPolicy{KeepWithin: 90 * time.Minute},
+			versions: versions(0, 1, 2, 3),
+			current:  version(0),
+			expected: versions(2, 3),
+		},
+		{
+			name:     "current is never pruned",
+			policy:   retentionPolicy{KeepLast: 1},
+			versions: versions(0, 1, 2),
+			current:  version(1),
+			expected: versions(2),
+		},
+		{
+			name:     "thin to one per day",
+			policy:   retentionPolicy{ThinTo: day},
+			versions: versions(1, 2, 13, 14, 37, 38),
+			current:  "",
+			expected: versions(2, 14, 38),
+		},
+		{
+			name:     "thinning ignores versions kept by other rules",
+			policy:   retentionPolicy{KeepLast: 1, ThinTo: day},
+			versions: versions(1, 2, 13, 14),
+			current:  version(1),
+			expected: versions(14),
+		},
+		{
+			name:     "unknown versions are left alone",
+			policy:   retentionPolicy{KeepLast: 1},
+			versions: append(versions(0, 1), "not-a-version"),
+			current:  version(0),
+			expected: versions(1),
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := tc.policy.prunable(tc.versions, tc.current, now)
+
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
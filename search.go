@@ -0,0 +1,391 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxSearchResults = 50
+	titleBoost       = 2.0
+	snippetBefore    = 60
+	snippetLength    = 200
+)
+
+// searchIndex is an inverted index over the current version of every article.
+type searchIndex struct {
+	mu sync.RWMutex
+
+	// postings maps a term to how many times it appears in each article
+	postings      map[string]map[string]int
+	titlePostings map[string]map[string]bool
+	contents      map[string]string
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings:      map[string]map[string]int{},
+		titlePostings: map[string]map[string]bool{},
+		contents:      map[string]string{},
+	}
+}
+
+type token struct {
+	term  string
+	start int
+	end   int
+}
+
+// tokenize splits text into lowercased runs of letters and digits.
+func tokenize(text string) []token {
+	tokens := []token{}
+	start := -1
+
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start == -1 {
+				start = i
+			}
+		} else if start != -1 {
+			tokens = append(tokens, token{term: strings.ToLower(text[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+
+	if start != -1 {
+		tokens = append(tokens, token{term: strings.ToLower(text[start:]), start: start, end: len(text)})
+	}
+
+	return tokens
+}
+
+func (idx *searchIndex) add(title string, content []byte) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.remove(title)
+
+	for _, t := range tokenize(string(content)) {
+		if idx.postings[t.term] == nil {
+			idx.postings[t.term] = map[string]int{}
+		}
+
+		idx.postings[t.term][title]++
+	}
+
+	for _, t := range tokenize(title) {
+		if idx.titlePostings[t.term] == nil {
+			idx.titlePostings[t.term] = map[string]bool{}
+		}
+
+		idx.titlePostings[t.term][title] = true
+	}
+
+	idx.contents[title] = string(content)
+}
+
+func (idx *searchIndex) delete(title string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.remove(title)
+}
+
+// remove must be called with the lock held.
+func (idx *searchIndex) remove(title string) {
+	content, ok := idx.contents[title]
+	if !ok {
+		return
+	}
+
+	for _, t := range tokenize(content) {
+		delete(idx.postings[t.term], title)
+		if len(idx.postings[t.term]) == 0 {
+			delete(idx.postings, t.term)
+		}
+	}
+
+	for _, t := range tokenize(title) {
+		delete(idx.titlePostings[t.term], title)
+		if len(idx.titlePostings[t.term]) == 0 {
+			delete(idx.titlePostings, t.term)
+		}
+	}
+
+	delete(idx.contents, title)
+}
+
+type searchResult struct {
+	Title   string
+	Snippet template.HTML
+	score   float64
+}
+
+// search ranks articles by the tf-idf of the query's terms, with matches in
+// the title counting extra.
+func (idx *searchIndex) search(query string) []searchResult {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	terms := map[string]bool{}
+	for _, t := range tokenize(query) {
+		terms[t.term] = true
+	}
+
+	n := float64(len(idx.contents))
+	scores := map[string]float64{}
+
+	for term := range terms {
+		df := len(idx.postings[term])
+		for title := range idx.titlePostings[term] {
+			if _, ok := idx.postings[term][title]; !ok {
+				df++
+			}
+		}
+
+		if df == 0 {
+			continue
+		}
+
+		idf := math.Log(1 + n/float64(df))
+
+		for title, tf := range idx.postings[term] {
+			scores[title] += (1 + math.Log(float64(tf))) * idf
+		}
+
+		for title := range idx.titlePostings[term] {
+			scores[title] += titleBoost * idf
+		}
+	}
+
+	results := []searchResult{}
+	for title, score := range scores {
+		results = append(results, searchResult{Title: title, score: score})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+
+		return results[i].Title < results[j].Title
+	})
+
+	if len(results) > maxSearchResults {
+		results = results[:maxSearchResults]
+	}
+
+	for i := range results {
+		results[i].Snippet = snippet(idx.contents[results[i].Title], terms)
+	}
+
+	return results
+}
+
+// snippet returns an excerpt of content around the first match of any term,
+// with every match in the excerpt highlighted.
+func snippet(content string, terms map[string]bool) template.HTML {
+	tokens := tokenize(content)
+
+	start := 0
+	for _, t := range tokens {
+		if terms[t.term] {
+			start = t.start - snippetBefore
+			break
+		}
+	}
+
+	if start < 0 {
+		start = 0
+	}
+
+	for start > 0 && !utf8.RuneStart(content[start]) {
+		start--
+	}
+
+	end := start + snippetLength
+	if end > len(content) {
+		end = len(content)
+	}
+
+	for end < len(content) && !utf8.RuneStart(content[end]) {
+		end++
+	}
+
+	var b strings.Builder
+
+	if start > 0 {
+		b.WriteString("&hellip;")
+	}
+
+	pos := start
+	for _, t := range tokens {
+		if t.start < start || t.end > end || !terms[t.term] {
+			continue
+		}
+
+		b.WriteString(html.EscapeString(content[pos:t.start]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(content[t.start:t.end]))
+		b.WriteString("</mark>")
+		pos = t.end
+	}
+
+	b.WriteString(html.EscapeString(content[pos:end]))
+
+	if end < len(content) {
+		b.WriteString("&hellip;")
+	}
+
+	return template.HTML(b.String())
+}
+
+// indexedStorage keeps a search index up to date with every change made
+// through it.
+type indexedStorage struct {
+	storage
+	index *searchIndex
+
+	// serializes reindexing so an older read can't overwrite a newer one
+	mu sync.Mutex
+}
+
+func newIndexedStorage(s storage, index *searchIndex) (storage, error) {
+	i := &indexedStorage{storage: s, index: index}
+
+	titles, err := s.ListArticles()
+	if err != nil {
+		return nil, fmt.Errorf("could not list articles: %w", err)
+	}
+
+	for _, title := range titles {
+		err := i.reindex(title)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return i, nil
+}
+
+func (i *indexedStorage) reindex(title string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	content, err := i.storage.ReadArticle(title)
+	if errors.Is(err, errArticleDNE) {
+		i.index.delete(title)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("could not index article '%s': %w", title, err)
+	}
+
+	if _, ok := redirectTarget(content); ok {
+		i.index.delete(title)
+		return nil
+	}
+
+	i.index.add(title, content)
+	return nil
+}
+
+// update reindexes articles after they've been changed. The change has been
+// saved by then, so failing only leaves the index out of date and is logged.
+func (i *indexedStorage) update(titles ...string) {
+	for _, title := range titles {
+		err := i.reindex(title)
+		if err != nil {
+			log.Printf("could not update search index: %s", err.Error())
+		}
+	}
+}
+
+func (i *indexedStorage) WriteArticle(title string, content []byte, meta versionMeta) error {
+	err := i.storage.WriteArticle(title, content, meta)
+	if err != nil {
+		return err
+	}
+
+	i.update(title)
+	return nil
+}
+
+func (i *indexedStorage) WriteArticleIfCurrent(title, base string, content []byte, meta versionMeta) error {
+	err := i.storage.WriteArticleIfCurrent(title, base, content, meta)
+	if err != nil {
+		return err
+	}
+
+	i.update(title)
+	return nil
+}
+
+func (i *indexedStorage) MoveArticle(from, to string, redirect []byte, meta versionMeta) error {
+	err := i.storage.MoveArticle(from, to, redirect, meta)
+	if err != nil {
+		return err
+	}
+
+	i.update(from, to)
+	return nil
+}
+
+func (i *indexedStorage) DeleteArticle(title string) error {
+	err := i.storage.DeleteArticle(title)
+	if err != nil {
+		return err
+	}
+
+	i.update(title)
+	return nil
+}
+
+func (i *indexedStorage) RestoreArticle(id string) error {
+	d, err := parseDeletedArticleID(id)
+	if err != nil {
+		return err
+	}
+
+	err = i.storage.RestoreArticle(id)
+	if err != nil {
+		return err
+	}
+
+	i.update(d.Title)
+	return nil
+}
+
+func newSearchHandler(index *searchIndex, tmpl *template.Template) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		searchHandler(w, r, index, tmpl)
+	})
+}
+
+type searchView struct {
+	Query   string
+	Results []searchResult
+}
+
+func searchHandler(w http.ResponseWriter, r *http.Request, index *searchIndex, tmpl *template.Template) {
+	query := r.URL.Query().Get("q")
+
+	render(
+		w,
+		tmpl,
+		"search_results.tmpl",
+		searchView{
+			Query:   query,
+			Results: index.search(query),
+		},
+	)
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tt := []struct {
+		name     string
+		text     string
+		expected []token
+	}{
+		{
+			name:     "empty",
+			text:     "",
+			expected: []token{},
+		},
+		{
+			name:     "only punctuation",
+			text:     " ,.! ",
+			expected: []token{},
+		},
+		{
+			name: "words",
+			text: "Hello, World!",
+			expected: []token{
+				{term: "hello", start: 0, end: 5},
+				{term: "world", start: 7, end: 12},
+			},
+		},
+		{
+			name: "letters and digits",
+			text: "abc123 x_y",
+			expected: []token{
+				{term: "abc123", start: 0, end: 6},
+				{term: "x", start: 7, end: 8},
+				{term: "y", start: 9, end: 10},
+			},
+		},
+		{
+			name: "multibyte offsets",
+			text: "Héllo wörld",
+			expected: []token{
+				{term: "héllo", start: 0, end: 6},
+				{term: "wörld", start: 7, end: 13},
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := tokenize(tc.text)
+
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestSearchRanking(t *testing.T) {
+	idx := newSearchIndex()
+	idx.add("Apples", []byte("apples are red. apples are sweet."))
+	idx.add("Pears", []byte("pears and apples and apples"))
+	idx.add("Fruit", []byte("some fruit like apples"))
+	idx.add("Salad", []byte("fruit fruit fruit fruit"))
+
+	tt := []struct {
+		name     string
+		query    string
+		expected []string
+	}{
+		{
+			name:     "empty query",
+			query:    "",
+			expected: []string{},
+		},
+		{
+			name:     "no matches",
+			query:    "banana",
+			expected: []string{},
+		},
+		{
+			name:     "more matches rank higher",
+			query:    "apples",
+			expected: []string{"Apples", "Pears", "Fruit"},
+		},
+		{
+			name:     "query is tokenized",
+			query:    "APPLES!",
+			expected: []string{"Apples", "Pears", "Fruit"},
+		},
+		{
+			name:     "title counts extra",
+			query:    "fruit",
+			expected: []string{"Fruit", "Salad"},
+		},
+		{
+			name:     "several terms",
+			query:    "red apples",
+			expected: []string{"Apples", "Pears", "Fruit"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := []string{}
+			for _, r := range idx.search(tc.query) {
+				actual = append(actual, r.Title)
+			}
+
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	tt := []struct {
+		name     string
+		content  string
+		terms    []string
+		expected template.HTML
+	}{
+		{
+			name:     "highlights matches",
+			content:  "an apple a day, Apple!",
+			terms:    []string{"apple"},
+			expected: "an <mark>apple</mark> a day, <mark>Apple</mark>!",
+		},
+		{
+			name:     "escapes html",
+			content:  "<b>apple</b> & pear",
+			terms:    []string{"apple", "pear"},
+			expected: "&lt;b&gt;<mark>apple</mark>&lt;/b&gt; &amp; <mark>pear</mark>",
+		},
+		{
+			name:     "no match starts at the beginning",
+			content:  strings.Repeat("y", 250),
+			terms:    []string{"apple"},
+			expected: template.HTML(strings.Repeat("y", 200) + "&hellip;"),
+		},
+		{
+			name:     "starts before the first match",
+			content:  strings.Repeat("x ", 100) + "apple",
+			terms:    []string{"apple"},
+			expected: template.HTML("&hellip;" + strings.Repeat("x ", 30) + "<mark>apple</mark>"),
+		},
+		{
+			name:     "doesn't split characters",
+			content:  "ab" + strings.Repeat("é", 40) + " apple",
+			terms:    []string{"apple"},
+			expected: template.HTML("&hellip;" + strings.Repeat("é", 30) + " <mark>apple</mark>"),
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			terms := map[string]bool{}
+			for _, term := range tc.terms {
+				terms[term] = true
+			}
+
+			actual := snippet(tc.content, terms)
+
+			if actual != tc.expected {
+				t.Fatalf("expected %q, got %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestIndexedStorage(t *testing.T) {
+	local, err := NewLocalStorage(t.TempDir(), retentionPolicy{})
+	if err != nil {
+		t.Fatalf("could not make storage: %s", err.Error())
+	}
+
+	idx := newSearchIndex()
+
+	s, err := newIndexedStorage(local, idx)
+	if err != nil {
+		t.Fatalf("could not make indexed storage: %s", err.Error())
+	}
+
+	// each step changes storage and searches for query afterwards
+	tt := []struct {
+		name     string
+		change   func() error
+		query    string
+		expected []string
+	}{
+		{
+			name: "write",
+			change: func() error {
+				return s.WriteArticle("Alpha", []byte("the quick fox"), versionMeta{})
+			},
+			query:    "fox",
+			expected: []string{"Alpha"},
+		},
+		{
+			name: "write if current",
+			change: func() error {
+				base, err := s.CurrentArticleVersion("Alpha")
+				if err != nil {
+					return err
+				}
+
+				return s.WriteArticleIfCurrent("Alpha", base, []byte("the lazy dog"), versionMeta{})
+			},
+			query:    "fox",
+			expected: []string{},
+		},
+		{
+			name:     "new content is found",
+			change:   func() error { return nil },
+			query:    "dog",
+			expected: []string{"Alpha"},
+		},
+		{
+			name: "move",
+			change: func() error {
+				return s.MoveArticle("Alpha", "Beta", redirectContent("Beta"), versionMeta{})
+			},
+			query:    "dog",
+			expected: []string{"Beta"},
+		},
+		{
+			name: "delete",
+			change: func() error {
+				return s.DeleteArticle("Beta")
+			},
+			query:    "dog",
+			expected: []string{},
+		},
+		{
+			name: "restore",
+			change: func() error {
+				deleted, err := s.ListDeletedArticles()
+				if err != nil {
+					return err
+				}
+
+				return s.RestoreArticle(deleted[0].ID)
+			},
+			query:    "dog",
+			expected: []string{"Beta"},
+		},
+	}
+
+	for _, tc := range tt {
+		err := tc.change()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tc.name, err.Error())
+		}
+
+		actual := []string{}
+		for _, r := range idx.search(tc.query) {
+			actual = append(actual, r.Title)
+		}
+
+		if !reflect.DeepEqual(actual, tc.expected) {
+			t.Fatalf("%s: expected %v, got %v", tc.name, tc.expected, actual)
+		}
+	}
+}
+
+// unreadableStorage saves articles but can't read them back.
+type unreadableStorage struct {
+	storage
+}
+
+func (unreadableStorage) ReadArticle(title string) ([]byte, error) {
+	return nil, errors.New("read failed")
+}
+
+func TestIndexedStorageReindexFails(t *testing.T) {
+	local, err := NewLocalStorage(t.TempDir(), retentionPolicy{})
+	if err != nil {
+		t.Fatalf("could not make storage: %s", err.Error())
+	}
+
+	s, err := newIndexedStorage(unreadableStorage{local}, newSearchIndex())
+	if err != nil {
+		t.Fatalf("could not make indexed storage: %s", err.Error())
+	}
+
+	// the article was saved, only the index is out of date
+	err = s.WriteArticle("Alpha", []byte("the quick fox"), versionMeta{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	err = s.MoveArticle("Alpha", "Beta", redirectContent("Beta"), versionMeta{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	err = s.DeleteArticle("Beta")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+}
@@ -1,53 +1,147 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 var (
-	errArticleDNE = errors.New("error: article does not exist")
+	errArticleDNE      = errors.New("error: article does not exist")
+	errVersionConflict = errors.New("error: article has changed since it was loaded")
+	errArticleExists   = errors.New("error: article already exists")
 )
 
 type storage interface {
-	WriteArticle(title string, content []byte) error
+	WriteArticle(title string, content []byte, meta versionMeta) error
+	// WriteArticleIfCurrent writes the article only if base is still its
+	// current version. An empty base means the article must not exist yet.
+	WriteArticleIfCurrent(title, base string, content []byte, meta versionMeta) error
+	CurrentArticleVersion(title string) (string, error)
 	ReadArticle(title string) ([]byte, error)
 	ReadArticleVersion(title, version string) ([]byte, error)
+	ReadArticleVersionInfo(title, version string) (versionInfo, error)
 	ListArticleVersions(title string) ([]string, error)
 	ListArticles() ([]string, error)
+	// MoveArticle renames an article, taking its history with it. If
+	// redirect is not nil it is written as the first version of a new
+	// article at the old title, and if that fails the move is undone.
+	MoveArticle(from, to string, redirect []byte, meta versionMeta) error
+	// DeleteArticle moves an article and its history into the trash.
+	DeleteArticle(title string) error
+	ListDeletedArticles() ([]deletedArticle, error)
+	RestoreArticle(id string) error
+}
+
+// versionMeta is recorded alongside the content of a version.
+type versionMeta struct {
+	// Author identifies who wrote the version.
+	Author string `json:"author,omitempty"`
+	// Summary is the editor's description of the change.
+	Summary string `json:"summary,omitempty"`
+	// RevertOf is the version whose content this version restored.
+	RevertOf string `json:"revert_of,omitempty"`
+
+	// Size and Parent are filled in by storage when the version is written.
+	Size   int    `json:"size"`
+	Parent string `json:"parent,omitempty"`
+}
+
+type versionInfo struct {
+	ID   string
+	Time time.Time
+	versionMeta
+}
+
+type deletedArticle struct {
+	ID        string
+	Title     string
+	DeletedAt time.Time
 }
 
 type localStorage struct {
 	baseDirectory string
+	retention     retentionPolicy
+
+	// serializes writes so conditional writes can't race
+	mu sync.Mutex
 }
 
-func NewLocalStorage(base string) (storage, error) {
+func NewLocalStorage(base string, retention retentionPolicy) (storage, error) {
 	baseDirectory, err := filepath.Abs(base)
 	if err != nil {
 		return nil, fmt.Errorf("could not resolve absolute file path: %w", err)
 	}
 
-	return &localStorage{baseDirectory: baseDirectory}, nil
+	return &localStorage{baseDirectory: baseDirectory, retention: retention}, nil
 }
 
-func (l *localStorage) WriteArticle(title string, content []byte) error {
+func (l *localStorage) WriteArticle(title string, content []byte, meta versionMeta) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.writeArticle(title, content, meta)
+}
+
+func (l *localStorage) WriteArticleIfCurrent(title, base string, content []byte, meta versionMeta) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	current, err := l.CurrentArticleVersion(title)
+	if errors.Is(err, errArticleDNE) {
+		current = ""
+	} else if err != nil {
+		return err
+	}
+
+	if current != base {
+		return errVersionConflict
+	}
+
+	return l.writeArticle(title, content, meta)
+}
+
+func (l *localStorage) writeArticle(title string, content []byte, meta versionMeta) error {
 	if !l.exists(title) {
 		err := os.Mkdir(l.relpath(title), 0755)
 		if err != nil {
 			return fmt.Errorf("could not make directory: %w", err)
 		}
+	} else {
+		parent, err := l.CurrentArticleVersion(title)
+		if err != nil {
+			return err
+		}
+
+		meta.Parent = parent
 	}
 
+	meta.Size = len(content)
+
 	fname := l.relpath(title, ts())
 	err := os.WriteFile(fname, content, 0644)
 	if err != nil {
 		return fmt.Errorf("could not write file: %w", err)
 	}
 
+	metadata, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("could not encode version metadata: %w", err)
+	}
+
+	err = os.WriteFile(fname+metaSuffix, metadata, 0644)
+	if err != nil {
+		return fmt.Errorf("could not write metadata file: %w", err)
+	}
+
 	newsym := fname + "_ptr"
 	err = os.Symlink(filepath.Base(fname), newsym)
 	if err != nil {
@@ -63,9 +157,76 @@ func (l *localStorage) WriteArticle(title string, content []byte) error {
 	// error here doesn't matter?
 	os.Remove(newsym)
 
+	err = l.prune(title)
+	if err != nil {
+		log.Printf("could not prune versions of '%s': %s", title, err.Error())
+	}
+
 	return nil
 }
 
+func (l *localStorage) prune(title string) error {
+	if !l.retention.enabled() {
+		return nil
+	}
+
+	current, err := l.CurrentArticleVersion(title)
+	if err != nil {
+		return err
+	}
+
+	versions, err := l.versions(title)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range l.retention.prunable(versions, current, time.Now()) {
+		err := os.Remove(l.relpath(title, v))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("could not remove version: %w", err)
+		}
+
+		err = os.Remove(l.relpath(title, v+metaSuffix))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("could not remove version metadata: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// versions returns the IDs of every stored version of an article, newest first.
+func (l *localStorage) versions(title string) ([]string, error) {
+	entries, err := os.ReadDir(l.relpath(title))
+	if err != nil {
+		return nil, fmt.Errorf("could not read directory: %w", err)
+	}
+
+	versions := []string{}
+	for _, e := range entries {
+		if _, err := versionTime(e.Name()); err == nil && e.Type().IsRegular() {
+			versions = append(versions, e.Name())
+		}
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(versions)))
+
+	return versions, nil
+}
+
+func (l *localStorage) CurrentArticleVersion(title string) (string, error) {
+	if !l.exists(title) {
+		return "", errArticleDNE
+	}
+
+	current, err := os.Readlink(l.relpath(title, "current"))
+	if err != nil {
+		return "", fmt.Errorf("could not read current symlink: %w", err)
+	}
+
+	return current, nil
+}
+
 func (l *localStorage) ReadArticle(title string) ([]byte, error) {
 	return l.ReadArticleVersion(title, "current")
 }
@@ -75,6 +236,10 @@ func (l *localStorage) ReadArticleVersion(title, version string) ([]byte, error)
 		return nil, errArticleDNE
 	}
 
+	if _, err := versionTime(version); err != nil && version != "current" {
+		return nil, err
+	}
+
 	data, err := os.ReadFile(l.relpath(title, version))
 	if err != nil {
 		return nil, fmt.Errorf("could not read file: %w", err)
@@ -83,26 +248,48 @@ func (l *localStorage) ReadArticleVersion(title, version string) ([]byte, error)
 	return data, nil
 }
 
-func (l *localStorage) ListArticleVersions(title string) ([]string, error) {
+func (l *localStorage) ReadArticleVersionInfo(title, version string) (versionInfo, error) {
+	info := versionInfo{ID: version}
+
 	if !l.exists(title) {
-		return nil, errArticleDNE
+		return info, errArticleDNE
 	}
 
-	entries, err := os.ReadDir(l.relpath(title))
+	t, err := versionTime(version)
 	if err != nil {
-		return nil, fmt.Errorf("could not read directory: %w", err)
+		return info, err
 	}
 
-	size := len(entries)
-	versions := make([]string, size)
+	info.Time = t
 
-	for i, e := range entries {
-		if !e.IsDir() {
-			versions[(size-1)-i] = e.Name()
+	data, err := os.ReadFile(l.relpath(title, version+metaSuffix))
+	if errors.Is(err, os.ErrNotExist) {
+		// versions written before metadata existed only know their size
+		fi, err := os.Stat(l.relpath(title, version))
+		if err != nil {
+			return info, fmt.Errorf("could not stat file: %w", err)
 		}
+
+		info.Size = int(fi.Size())
+		return info, nil
+	} else if err != nil {
+		return info, fmt.Errorf("could not read metadata file: %w", err)
 	}
 
-	return versions, nil
+	err = json.Unmarshal(data, &info.versionMeta)
+	if err != nil {
+		return info, fmt.Errorf("could not decode version metadata: %w", err)
+	}
+
+	return info, nil
+}
+
+func (l *localStorage) ListArticleVersions(title string) ([]string, error) {
+	if !l.exists(title) {
+		return nil, errArticleDNE
+	}
+
+	return l.versions(title)
 }
 
 func (l *localStorage) ListArticles() ([]string, error) {
@@ -113,7 +300,7 @@ func (l *localStorage) ListArticles() ([]string, error) {
 
 	titles := []string{}
 	for _, e := range entries {
-		if e.IsDir() {
+		if e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
 			titles = append(titles, e.Name())
 		}
 	}
@@ -121,6 +308,134 @@ func (l *localStorage) ListArticles() ([]string, error) {
 	return titles, nil
 }
 
+func (l *localStorage) MoveArticle(from, to string, redirect []byte, meta versionMeta) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.exists(from) {
+		return errArticleDNE
+	}
+
+	err := l.move(l.relpath(from), l.relpath(to))
+	if err != nil || redirect == nil {
+		return err
+	}
+
+	err = l.writeArticle(from, redirect, meta)
+	if err != nil {
+		// put the article back rather than leave its old title empty
+		os.RemoveAll(l.relpath(from))
+
+		if undoErr := l.move(l.relpath(to), l.relpath(from)); undoErr != nil {
+			return fmt.Errorf("could not undo move after failing to write redirect (%v): %w", err, undoErr)
+		}
+
+		return fmt.Errorf("could not write redirect: %w", err)
+	}
+
+	return nil
+}
+
+func (l *localStorage) DeleteArticle(title string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.exists(title) {
+		return errArticleDNE
+	}
+
+	err := os.MkdirAll(l.relpath(trashDirectory), 0755)
+	if err != nil {
+		return fmt.Errorf("could not make trash directory: %w", err)
+	}
+
+	return l.move(l.relpath(title), l.relpath(trashDirectory, title+"."+ts()))
+}
+
+func (l *localStorage) ListDeletedArticles() ([]deletedArticle, error) {
+	entries, err := os.ReadDir(l.relpath(trashDirectory))
+	if errors.Is(err, os.ErrNotExist) {
+		return []deletedArticle{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("could not read trash directory: %w", err)
+	}
+
+	deleted := []deletedArticle{}
+	for _, e := range entries {
+		d, err := parseDeletedArticleID(e.Name())
+		if err == nil && e.IsDir() {
+			deleted = append(deleted, d)
+		}
+	}
+
+	sort.Slice(deleted, func(i, j int) bool {
+		return deleted[i].DeletedAt.After(deleted[j].DeletedAt)
+	})
+
+	return deleted, nil
+}
+
+func (l *localStorage) RestoreArticle(id string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	d, err := parseDeletedArticleID(id)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(l.relpath(trashDirectory, id)); err != nil {
+		return errArticleDNE
+	}
+
+	return l.move(l.relpath(trashDirectory, id), l.relpath(d.Title))
+}
+
+// move renames an article directory without replacing an existing one.
+func (l *localStorage) move(from, to string) error {
+	if _, err := os.Stat(to); err == nil {
+		return errArticleExists
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not stat directory: %w", err)
+	}
+
+	err := os.Rename(from, to)
+	if err != nil {
+		return fmt.Errorf("could not move directory: %w", err)
+	}
+
+	return nil
+}
+
+// deleted articles are named <title>.<time deleted>
+func parseDeletedArticleID(id string) (deletedArticle, error) {
+	d := deletedArticle{ID: id}
+
+	idx := strings.LastIndex(id, ".")
+	if idx == -1 {
+		return d, fmt.Errorf("invalid deleted article ID: %q", id)
+	}
+
+	t, err := versionTime(id[idx+1:])
+	if err != nil {
+		return d, fmt.Errorf("invalid deleted article ID: %q", id)
+	}
+
+	d.Title = id[:idx]
+	d.DeletedAt = t
+
+	if !titleMatcher.MatchString(d.Title) {
+		return d, fmt.Errorf("invalid deleted article ID: %q", id)
+	}
+
+	return d, nil
+}
+
+const (
+	metaSuffix     = ".meta"
+	trashDirectory = ".trash"
+)
+
 func ts() string {
 	return strconv.FormatInt(time.Now().UnixNano(), 10)
 }
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMoveArticle(t *testing.T) {
+	s, err := NewLocalStorage(t.TempDir(), retentionPolicy{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	for _, title := range []string{"Old", "Taken"} {
+		err = s.WriteArticle(title, []byte(title+" content"), versionMeta{})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+
+	err = s.MoveArticle("Old", "Taken", redirectContent("Taken"), versionMeta{})
+	if !errors.Is(err, errArticleExists) {
+		t.Fatalf("expected %v, got %v", errArticleExists, err)
+	}
+
+	content, err := s.ReadArticle("Old")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	if string(content) != "Old content" {
+		t.Fatalf("failed move changed the article to %q", content)
+	}
+
+	err = s.MoveArticle("Old", "New", redirectContent("New"), versionMeta{Summary: "Moved to New"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	content, err = s.ReadArticle("New")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	if string(content) != "Old content" {
+		t.Fatalf("expected the moved article's content, got %q", content)
+	}
+
+	content, err = s.ReadArticle("Old")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	if target, ok := redirectTarget(content); !ok || target != "New" {
+		t.Fatalf("expected a redirect to New, got %q", content)
+	}
+
+	versions, err := s.ListArticleVersions("Old")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	if len(versions) != 1 {
+		t.Fatalf("expected the redirect to be the only version, got %d versions", len(versions))
+	}
+
+	err = s.MoveArticle("New", "Newer", nil, versionMeta{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	_, err = s.ReadArticle("New")
+	if !errors.Is(err, errArticleDNE) {
+		t.Fatalf("expected no redirect to be left, got %v", err)
+	}
+}
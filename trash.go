@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+)
+
+var errTrashDisabled = errors.New("error: no admin is configured")
+
+func deleteArticle(w http.ResponseWriter, r *http.Request, s storage, tmpl *template.Template, title string) {
+	err := s.DeleteArticle(title)
+	if err != nil {
+		renderError(w, tmpl, fmt.Errorf("could not delete article: %w", err))
+		return
+	}
+
+	http.Redirect(w, r, r.URL.Path, http.StatusFound)
+}
+
+func newTrashHandler(s storage, adminUser, adminPassword string, loc *time.Location, tmpl *template.Template) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if adminUser == "" || adminPassword == "" {
+			renderError(w, tmpl, errTrashDisabled)
+			return
+		}
+
+		user, password, ok := r.BasicAuth()
+		if !ok || !secureEqual(user, adminUser) || !secureEqual(password, adminPassword) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="trash"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		trashHandler(w, r, s, loc, tmpl)
+	})
+}
+
+type deletedArticleView struct {
+	deletedArticle
+	Timestamp string
+}
+
+func trashHandler(w http.ResponseWriter, r *http.Request, s storage, loc *time.Location, tmpl *template.Template) {
+	if r.Method == "POST" {
+		restoreArticle(w, r, s, tmpl)
+		return
+	}
+
+	deleted, err := s.ListDeletedArticles()
+	if err != nil {
+		renderError(w, tmpl, fmt.Errorf("could not list deleted articles: %w", err))
+		return
+	}
+
+	views := make([]deletedArticleView, len(deleted))
+	for i, d := range deleted {
+		views[i] = deletedArticleView{
+			deletedArticle: d,
+			Timestamp:      d.DeletedAt.In(loc).Format(timestampFormat),
+		}
+	}
+
+	render(w, tmpl, "list_deleted_articles.tmpl", views)
+}
+
+func restoreArticle(w http.ResponseWriter, r *http.Request, s storage, tmpl *template.Template) {
+	err := r.ParseForm()
+	if err != nil {
+		renderError(w, tmpl, fmt.Errorf("could not parse form values: %w", err))
+		return
+	}
+
+	id := r.Form.Get("id")
+
+	d, err := parseDeletedArticleID(id)
+	if err != nil {
+		renderError(w, tmpl, err)
+		return
+	}
+
+	err = s.RestoreArticle(id)
+	if err != nil {
+		renderError(w, tmpl, fmt.Errorf("could not restore article: %w", err))
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/articles/%s", d.Title), http.StatusFound)
+}
+
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
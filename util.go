@@ -5,9 +5,12 @@ import (
 	"html/template"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
 
-	"github.com/packrat386/atalanta/internal/markdown"
+	"github.com/packrat386/atalanta/markdown"
 )
 
 func render(w io.Writer, tmpl *template.Template, name string, data interface{}) {
@@ -26,21 +29,85 @@ func renderError(w http.ResponseWriter, tmpl *template.Template, err error) {
 	render(w, tmpl, "error.tmpl", errorView{ErrorMessage: err.Error()})
 }
 
-func md2html(input []byte) (template.HTML, error) {
-	html, err := markdown.GenerateHTML(input)
+// requestAuthor identifies who made a request. There are no user accounts so
+// this is the client's IP address.
+func requestAuthor(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// hasTasks reports whether an article's markdown has any task list items.
+func hasTasks(input []byte) bool {
+	doc, err := markdown.Parse(input)
+	if err != nil {
+		return false
+	}
+
+	found := false
+
+	markdown.Walk(doc, func(n *markdown.Node) bool {
+		if n.Kind == markdown.NodeListItem && n.Task {
+			found = true
+		}
+
+		return !found
+	})
+
+	return found
+}
+
+// md2html renders an article's markdown. If toggleTasks is set the article
+// is expected to be shown inside a form that toggles its tasks.
+func md2html(input []byte, s storage, toggleTasks bool) (template.HTML, error) {
+	doc, err := markdown.Parse(input)
 	if err != nil {
 		return template.HTML(""), fmt.Errorf("error generating html from markdown: %w", err)
 	}
 
-	return template.HTML(string(html)), nil
+	var html strings.Builder
+
+	err = markdown.HTMLRenderer{Links: articleLinks{s: s}, ToggleTasks: toggleTasks}.Render(&html, doc)
+	if err != nil {
+		return template.HTML(""), fmt.Errorf("error generating html from markdown: %w", err)
+	}
+
+	return template.HTML(html.String()), nil
+}
+
+// articleLinks resolves [[Title]] links in markdown to articles in storage.
+// Spaces in the title stand in for underscores.
+type articleLinks struct {
+	s storage
 }
 
+func (a articleLinks) ResolveLink(title string) (string, bool) {
+	title = strings.ReplaceAll(title, " ", "_")
+	if !titleMatcher.MatchString(title) {
+		return "/articles/" + url.PathEscape(title), false
+	}
+
+	_, err := a.s.CurrentArticleVersion(title)
+	return "/articles/" + title, err == nil
+}
+
+// checkmd checks markdown before it is saved. Saved articles are rendered
+// even if they break the parser's limits, but new versions have to keep to
+// them.
 func checkmd(input []byte) error {
-	_, err := markdown.GenerateHTML(input)
+	doc, err := markdown.ParseWithOptions(input, markdown.ParseOptions{Strict: true})
 	if err != nil {
 		return fmt.Errorf("error parsing markdown: %w", err)
 	}
 
+	err = markdown.HTMLRenderer{}.Render(io.Discard, doc)
+	if err != nil {
+		return fmt.Errorf("error generating html from markdown: %w", err)
+	}
+
 	return nil
 }
 
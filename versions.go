@@ -1,54 +1,74 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func newVersionHandler(s storage, tmpl *template.Template) http.Handler {
+const versionsPerPage = 50
+
+const timestampFormat = "2006-01-02 15:04:05 MST"
+
+func newVersionHandler(s storage, loc *time.Location, tmpl *template.Template) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		versionHandler(w, r, s, tmpl)
+		versionHandler(w, r, s, loc, tmpl)
 	})
 }
 
 type versionListView struct {
-	Title      string
-	VersionIDs []string
+	Title    string
+	Versions []versionListItem
+	PrevPage int
+	NextPage int
+}
+
+type versionListItem struct {
+	versionInfo
+	Timestamp  string
+	Delta      int
+	PreviousID string
 }
 
 type versionView struct {
-	Title     string
-	VersionID string
+	Title string
+	versionInfo
+	Timestamp string
 	Content   template.HTML
 }
 
-func versionHandler(w http.ResponseWriter, r *http.Request, s storage, tmpl *template.Template) {
+func versionHandler(w http.ResponseWriter, r *http.Request, s storage, loc *time.Location, tmpl *template.Template) {
 	title, err := versionTitle(r)
 	if err != nil {
 		renderError(w, tmpl, err)
 		return
 	}
 
+	if r.Method == "POST" {
+		revertVersion(w, r, s, tmpl, title)
+		return
+	}
+
+	from, to := r.URL.Query().Get("from"), r.URL.Query().Get("to")
+	if from != "" && to != "" {
+		diffVersions(w, s, tmpl, title, from, to)
+		return
+	}
+
 	versionID := r.URL.Query().Get("version_id")
 	if versionID == "" {
-		versions, err := s.ListArticleVersions(title)
-		if err != nil {
-			renderError(w, tmpl, fmt.Errorf("could not list article versions: %w", err))
-			return
-		}
-
-		render(
-			w,
-			tmpl,
-			"list_article_versions.tmpl",
-			versionListView{
-				Title:      title,
-				VersionIDs: versions,
-			},
-		)
+		listVersions(w, r, s, loc, tmpl, title)
+		return
+	}
 
+	versionID, err = resolveVersion(s, title, versionID)
+	if err != nil {
+		renderError(w, tmpl, err)
 		return
 	}
 
@@ -63,20 +83,313 @@ func versionHandler(w http.ResponseWriter, r *http.Request, s storage, tmpl *tem
 		return
 	}
 
-	contentHTML, err := md2html(content)
+	info, err := s.ReadArticleVersionInfo(title, versionID)
+	if err != nil {
+		renderError(w, tmpl, fmt.Errorf("could not get info of article version: %w", err))
+		return
+	}
+
+	contentHTML, err := md2html(content, s, false)
 	if err != nil {
 		renderError(w, tmpl, err)
+		return
 	}
 
 	a := versionView{
-		Title:     title,
-		VersionID: versionID,
-		Content:   contentHTML,
+		Title:       title,
+		versionInfo: info,
+		Timestamp:   info.Time.In(loc).Format(timestampFormat),
+		Content:     contentHTML,
 	}
 
 	render(w, tmpl, "show_article_version.tmpl", a)
 }
 
+// listVersions shows one page of an article's history, newest first.
+func listVersions(w http.ResponseWriter, r *http.Request, s storage, loc *time.Location, tmpl *template.Template, title string) {
+	page := 1
+	if p := r.URL.Query().Get("page"); p != "" {
+		var err error
+		page, err = strconv.Atoi(p)
+		if err != nil || page < 1 {
+			renderError(w, tmpl, fmt.Errorf("invalid page: %q", p))
+			return
+		}
+	}
+
+	versions, err := s.ListArticleVersions(title)
+	if err != nil {
+		renderError(w, tmpl, fmt.Errorf("could not list article versions: %w", err))
+		return
+	}
+
+	// pages past the end are all empty, clamping first keeps the
+	// multiplication from overflowing
+	lastPage := (len(versions) + versionsPerPage - 1) / versionsPerPage
+	if lastPage < 1 {
+		lastPage = 1
+	}
+
+	if page > lastPage {
+		page = lastPage
+	}
+
+	start := (page - 1) * versionsPerPage
+
+	end := start + versionsPerPage
+	if end > len(versions) {
+		end = len(versions)
+	}
+
+	// the version just past the end of the page is needed for the last delta
+	infos := []versionInfo{}
+	for i := start; i < end+1 && i < len(versions); i++ {
+		info, err := s.ReadArticleVersionInfo(title, versions[i])
+		if err != nil {
+			renderError(w, tmpl, fmt.Errorf("could not get info of article version: %w", err))
+			return
+		}
+
+		infos = append(infos, info)
+	}
+
+	items := make([]versionListItem, end-start)
+	for i := range items {
+		items[i].versionInfo = infos[i]
+		items[i].Timestamp = infos[i].Time.In(loc).Format(timestampFormat)
+		items[i].Delta = infos[i].Size
+
+		if i+1 < len(infos) {
+			items[i].PreviousID = infos[i+1].ID
+			items[i].Delta -= infos[i+1].Size
+		}
+	}
+
+	view := versionListView{
+		Title:    title,
+		Versions: items,
+	}
+
+	if page > 1 {
+		view.PrevPage = page - 1
+	}
+
+	if end < len(versions) {
+		view.NextPage = page + 1
+	}
+
+	render(w, tmpl, "list_article_versions.tmpl", view)
+}
+
+// revertVersion restores the content of an old version by writing it as a
+// new version, so history stays linear.
+func revertVersion(w http.ResponseWriter, r *http.Request, s storage, tmpl *template.Template, title string) {
+	err := r.ParseForm()
+	if err != nil {
+		renderError(w, tmpl, fmt.Errorf("could not parse form values: %w", err))
+		return
+	}
+
+	versionID, err := resolveVersion(s, title, r.Form.Get("version_id"))
+	if err != nil {
+		renderError(w, tmpl, err)
+		return
+	}
+
+	content, err := s.ReadArticleVersion(title, versionID)
+	if err != nil {
+		renderError(w, tmpl, fmt.Errorf("could not get content of article version: %w", err))
+		return
+	}
+
+	meta := versionMeta{
+		Author:   requestAuthor(r),
+		Summary:  r.Form.Get("summary"),
+		RevertOf: versionID,
+	}
+
+	err = s.WriteArticle(title, content, meta)
+	if err != nil {
+		renderError(w, tmpl, fmt.Errorf("could not write article content:  %w", err))
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/articles/%s", title), http.StatusFound)
+}
+
+// resolveVersion turns the version ID "current" into the ID of the current
+// version, so it can be recorded or have its info read.
+func resolveVersion(s storage, title, versionID string) (string, error) {
+	if versionID != "current" {
+		return versionID, nil
+	}
+
+	current, err := s.CurrentArticleVersion(title)
+	if err != nil {
+		return "", fmt.Errorf("could not get current article version: %w", err)
+	}
+
+	return current, nil
+}
+
+type versionDiffView struct {
+	Title string
+	From  string
+	To    string
+	Lines []diffLineView
+
+	// TooDifferent is set instead of Lines when the versions changed too much
+	// to compare them
+	TooDifferent bool
+}
+
+type diffLineView struct {
+	Kind     string
+	Segments []diffSegmentView
+}
+
+type diffSegmentView struct {
+	Text    string
+	Changed bool
+}
+
+func diffVersions(w http.ResponseWriter, s storage, tmpl *template.Template, title, from, to string) {
+	fromContent, err := s.ReadArticleVersion(title, from)
+	if err != nil {
+		renderError(w, tmpl, fmt.Errorf("could not get content of article version: %w", err))
+		return
+	}
+
+	toContent, err := s.ReadArticleVersion(title, to)
+	if err != nil {
+		renderError(w, tmpl, fmt.Errorf("could not get content of article version: %w", err))
+		return
+	}
+
+	lines, err := diffLineViews(trimLines(splitLines(fromContent)), trimLines(splitLines(toContent)))
+	tooDifferent := errors.Is(err, errTooDifferent)
+	if err != nil && !tooDifferent {
+		renderError(w, tmpl, fmt.Errorf("could not diff article versions: %w", err))
+		return
+	}
+
+	render(
+		w,
+		tmpl,
+		"diff_article_versions.tmpl",
+		versionDiffView{
+			Title:        title,
+			From:         from,
+			To:           to,
+			Lines:        lines,
+			TooDifferent: tooDifferent,
+		},
+	)
+}
+
+// diffLineViews builds a line diff of two texts. Where a run of removed lines
+// is directly replaced by added lines, each pair is also diffed word by word
+// so the changed words can be highlighted.
+func diffLineViews(from, to []string) ([]diffLineView, error) {
+	edits, err := diffStrings(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := []diffLineView{}
+
+	for i := 0; i < len(edits); {
+		if edits[i].op == diffEqual {
+			lines = append(lines, diffLineView{
+				Kind:     "equal",
+				Segments: []diffSegmentView{{Text: edits[i].text}},
+			})
+			i++
+			continue
+		}
+
+		deleted, inserted := []string{}, []string{}
+		for ; i < len(edits) && edits[i].op == diffDelete; i++ {
+			deleted = append(deleted, edits[i].text)
+		}
+		for ; i < len(edits) && edits[i].op == diffInsert; i++ {
+			inserted = append(inserted, edits[i].text)
+		}
+
+		deletedLines := make([]diffLineView, len(deleted))
+		insertedLines := make([]diffLineView, len(inserted))
+
+		for j := range deleted {
+			if j < len(inserted) {
+				deletedLines[j], insertedLines[j] = diffWordViews(deleted[j], inserted[j])
+			} else {
+				deletedLines[j] = diffLineView{
+					Kind:     "delete",
+					Segments: []diffSegmentView{{Text: deleted[j]}},
+				}
+			}
+		}
+
+		for j := len(deleted); j < len(inserted); j++ {
+			insertedLines[j] = diffLineView{
+				Kind:     "insert",
+				Segments: []diffSegmentView{{Text: inserted[j]}},
+			}
+		}
+
+		lines = append(lines, deletedLines...)
+		lines = append(lines, insertedLines...)
+	}
+
+	return lines, nil
+}
+
+// trimLines drops line endings, each line of a diff is displayed on its own
+func trimLines(lines []string) []string {
+	trimmed := make([]string, len(lines))
+	for i, l := range lines {
+		trimmed[i] = strings.TrimRight(l, "\r\n")
+	}
+
+	return trimmed
+}
+
+func diffWordViews(from, to string) (diffLineView, diffLineView) {
+	deleted := diffLineView{Kind: "delete", Segments: []diffSegmentView{}}
+	inserted := diffLineView{Kind: "insert", Segments: []diffSegmentView{}}
+
+	edits, err := diffStrings(splitWords(from), splitWords(to))
+	if err != nil {
+		// lines too different to diff word by word are changed as a whole
+		edits = []diffEdit{{op: diffDelete, text: from}, {op: diffInsert, text: to}}
+	}
+
+	for _, e := range edits {
+		switch e.op {
+		case diffEqual:
+			deleted.Segments = appendSegment(deleted.Segments, e.text, false)
+			inserted.Segments = appendSegment(inserted.Segments, e.text, false)
+		case diffDelete:
+			deleted.Segments = appendSegment(deleted.Segments, e.text, true)
+		case diffInsert:
+			inserted.Segments = appendSegment(inserted.Segments, e.text, true)
+		}
+	}
+
+	return deleted, inserted
+}
+
+// appendSegment merges adjacent words with the same state so that a changed
+// phrase is highlighted as a whole.
+func appendSegment(segments []diffSegmentView, text string, changed bool) []diffSegmentView {
+	if len(segments) > 0 && segments[len(segments)-1].Changed == changed {
+		segments[len(segments)-1].Text += text
+		return segments
+	}
+
+	return append(segments, diffSegmentView{Text: text, Changed: changed})
+}
+
 var versionPathMatcher = regexp.MustCompile(`^/versions/([0-9a-zA-Z_]+)$`)
 
 func versionTitle(r *http.Request) (string, error) {
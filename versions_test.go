@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestVersionHandler(t *testing.T) (http.Handler, storage) {
+	tmpl, err := template.ParseFS(templatesFS, "templates/*.tmpl")
+	if err != nil {
+		t.Fatalf("could not parse templates: %s", err.Error())
+	}
+
+	s, err := NewLocalStorage(t.TempDir(), retentionPolicy{})
+	if err != nil {
+		t.Fatalf("could not make storage: %s", err.Error())
+	}
+
+	return newVersionHandler(s, time.UTC, tmpl), s
+}
+
+func TestDiffVersionsTooDifferent(t *testing.T) {
+	h, s := newTestVersionHandler(t)
+
+	from, to := &strings.Builder{}, &strings.Builder{}
+	for i := 0; i < maxDiffEdits; i++ {
+		fmt.Fprintf(from, "from %d\n", i)
+		fmt.Fprintf(to, "to %d\n", i)
+	}
+
+	for _, content := range []string{from.String(), to.String()} {
+		if err := s.WriteArticle("Different", []byte(content), versionMeta{}); err != nil {
+			t.Fatalf("could not write article: %s", err.Error())
+		}
+	}
+
+	versions, err := s.ListArticleVersions("Different")
+	if err != nil {
+		t.Fatalf("could not list versions: %s", err.Error())
+	}
+
+	w := getPage(h, "/versions/Different?from="+versions[0]+"&to="+versions[1])
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	if !strings.Contains(w.Body.String(), "too different to show the changes") {
+		t.Fatalf("expected the diff to be skipped, got:\n%s", w.Body.String())
+	}
+}
+
+func TestListVersionsPages(t *testing.T) {
+	tt := []struct {
+		name     string
+		versions int
+		page     string
+		items    int
+		newer    string
+		older    string
+	}{
+		{"one page", 1, "1", 1, "", ""},
+		{"exactly one page", versionsPerPage, "1", versionsPerPage, "", ""},
+		{"past exactly one page", versionsPerPage, "2", versionsPerPage, "", ""},
+		{"first of exactly two pages", 2 * versionsPerPage, "1", versionsPerPage, "", "2"},
+		{"last of exactly two pages", 2 * versionsPerPage, "2", versionsPerPage, "1", ""},
+		{"past exactly two pages", 2 * versionsPerPage, "3", versionsPerPage, "1", ""},
+		{"far past the end", 2 * versionsPerPage, "9223372036854775807", versionsPerPage, "1", ""},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			h, s := newTestVersionHandler(t)
+
+			for i := 0; i < tc.versions; i++ {
+				err := s.WriteArticle("Paged", []byte(fmt.Sprintf("version %d\n", i)), versionMeta{})
+				if err != nil {
+					t.Fatalf("could not write article: %s", err.Error())
+				}
+			}
+
+			body := getPage(h, "/versions/Paged?page="+tc.page).Body.String()
+
+			if items := strings.Count(body, `name="to"`); items != tc.items {
+				t.Fatalf("expected %d versions, got %d", tc.items, items)
+			}
+
+			for _, link := range []struct{ label, page string }{{"Newer", tc.newer}, {"Older", tc.older}} {
+				if link.page == "" && strings.Contains(body, link.label) {
+					t.Fatalf("expected no %s link, got:\n%s", link.label, body)
+				}
+
+				if link.page != "" && !strings.Contains(body, "?page="+link.page+`">`+link.label) {
+					t.Fatalf("expected %s link to page %s, got:\n%s", link.label, link.page, body)
+				}
+			}
+		})
+	}
+}